@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// magic bytes of compression formats, and the extension of compressed files
+var compressionFormats = []struct {
+	name      string
+	magic     []byte
+	extension string
+}{
+	{"gzip", []byte{0x1F, 0x8B}, ".gz"},
+	{"bzip2", []byte("BZh"), ".bz2"},
+	{"zstd", []byte{0x28, 0xB5, 0x2F, 0xFD}, ".zst"},
+	{"xz", []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}, ".xz"},
+}
+
+// decompressReader wraps the reader with a decompressor if the content starts with magic bytes of gzip,
+// bzip2, zstd or xz. Returns the format, empty if the content is not compressed and returned as is
+func decompressReader(reader io.Reader) (io.ReadCloser, string, error) {
+	buffered := bufio.NewReader(reader)
+	head, err := buffered.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+
+	for _, format := range compressionFormats {
+		if !bytes.HasPrefix(head, format.magic) {
+			continue
+		}
+		switch format.name {
+		case "gzip":
+			decompressor, err := gzip.NewReader(buffered)
+			return decompressor, format.name, err
+		case "bzip2":
+			return ioutil.NopCloser(bzip2.NewReader(buffered)), format.name, nil
+		case "zstd":
+			decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+			if err != nil {
+				return nil, format.name, err
+			}
+			return decoder.IOReadCloser(), format.name, nil
+		case "xz":
+			decompressor, err := xz.NewReader(buffered)
+			return ioutil.NopCloser(decompressor), format.name, err
+		}
+	}
+	return ioutil.NopCloser(buffered), "", nil
+}
+
+// trimCompressionExtension returns the name of the file once decompressed, e.g. app.log for app.log.3.gz
+func trimCompressionExtension(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, format := range compressionFormats {
+		if ext == format.extension {
+			path = path[:len(path)-len(ext)]
+			// rotated logs are numbered before the extension
+			if rotation := filepath.Ext(path); len(rotation) > 1 {
+				if _, err := strconv.Atoi(rotation[1:]); err == nil {
+					path = strings.TrimSuffix(path, rotation)
+				}
+			}
+			break
+		}
+	}
+	return path
+}
+
+// DecompressedPreview reads the first maxSize bytes of the decompressed content of a file, while it is streamed.
+// The decompressed size is unknown until the end, Truncated tells whether there is more once the preview is read
+type DecompressedPreview struct {
+	*truncatingReader
+	Format       string // compression format, empty if the file is not compressed
+	stdout       *StdoutChannel
+	decompressor io.ReadCloser
+	cancel       context.CancelFunc
+}
+
+func (self *DecompressedPreview) Close() {
+	self.decompressor.Close()
+	self.stdout.Close()
+	// ends `cat` if the rest is not read, client-go would otherwise keep streaming it
+	self.cancel()
+}
+
+// GetDecompressedPreview streams the file and decompresses it on the fly, the preview returns the first maxSize bytes.
+// The file is streamed until the preview is closed or ctx is done
+func GetDecompressedPreview(ctx context.Context, podName string, containerName string, path string, namespace string, token string, maxSize int64) (*DecompressedPreview, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stdout, err := DownloadSingleFile(ctx, podName, containerName, path, namespace, token)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	decompressor, format, err := decompressReader(stdout.Reader())
+	if err != nil {
+		stdout.Close()
+		cancel()
+		return nil, err
+	}
+	return &DecompressedPreview{
+		truncatingReader: newTruncatingReader(decompressor, maxSize),
+		Format:           format,
+		stdout:           stdout,
+		decompressor:     decompressor,
+		cancel:           cancel,
+	}, nil
+}
+
+type ArchiveEntry struct {
+	FileInfo
+	Mode           string `json:"mode"`                     // e.g. -rw-r--r--
+	CompressedSize *int64 `json:"compressedSize,omitempty"` // zip only
+}
+
+type ArchiveListing struct {
+	Format    string         `json:"format"` // tar, tar.gz, tar.bz2, tar.zst, tar.xz or zip
+	Entries   []ArchiveEntry `json:"entries"`
+	Truncated bool           `json:"truncated"` // more entries than the limit
+}
+
+func newArchiveEntry(name string, isDir bool, size int64, modTime time.Time, mode string) ArchiveEntry {
+	name = strings.TrimPrefix(name, "./")
+	trimmed := strings.TrimRight(name, "/")
+	entry := ArchiveEntry{
+		FileInfo: FileInfo{
+			Name:      trimmed[strings.LastIndex(trimmed, "/")+1:],
+			Path:      name,
+			IsDir:     isDir,
+			Time:      modTime.UTC().Format("2006-01-02 15:04"),
+			Timestamp: modTime.Unix(),
+		},
+		Mode: mode,
+	}
+	if !isDir {
+		entry.Size = &size
+	}
+	return entry
+}
+
+// zip files have the list of entries (central directory) at their end
+var zipExtensions = map[string]bool{".zip": true, ".jar": true, ".war": true, ".ear": true, ".apk": true, ".whl": true}
+
+// largest central directory read from a zip file
+const maxZipDirectorySize = 64 * 1024 * 1024
+
+// remoteSuffixReader implements io.ReaderAt over the end of a file in the container. Reading before the loaded
+// part fetches the file from that offset to its end with `tail`, which is enough for the central directory of zip
+type remoteSuffixReader struct {
+	podName, containerName, path, namespace, token string
+	size                                           int64
+	start                                          int64
+	data                                           []byte
+}
+
+func (self *remoteSuffixReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= self.size {
+		return 0, io.EOF
+	}
+	if off < self.start || self.data == nil {
+		if self.size-off > maxZipDirectorySize {
+			return 0, fmt.Errorf("Zip central directory is larger than %d bytes", maxZipDirectorySize)
+		}
+		cmd := []string{"tail", "-c", "+" + strconv.FormatInt(off+1, 10), self.path}
+		data, err := execCmd(self.podName, self.containerName, self.namespace, self.token, cmd)
+		if err != nil {
+			return 0, err
+		}
+		self.start, self.data = off, data
+	}
+	n := copy(p, self.data[off-self.start:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func listZipEntries(podName string, containerName string, path string, namespace string, token string, size int64, limit int) (*ArchiveListing, error) {
+	reader := &remoteSuffixReader{podName: podName, containerName: containerName, path: path, namespace: namespace, token: token, size: size}
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &ArchiveListing{Format: "zip", Entries: make([]ArchiveEntry, 0)}
+	for _, file := range archive.File {
+		if limit > 0 && len(listing.Entries) >= limit {
+			listing.Truncated = true
+			break
+		}
+		info := file.FileInfo()
+		entry := newArchiveEntry(file.Name, info.IsDir(), int64(file.UncompressedSize64), file.Modified, info.Mode().String())
+		compressedSize := int64(file.CompressedSize64)
+		entry.CompressedSize = &compressedSize
+		listing.Entries = append(listing.Entries, entry)
+	}
+	return listing, nil
+}
+
+// listTarEntries streams the archive until `limit` entries are read, `cat` is ended then rather than streaming the rest
+func listTarEntries(ctx context.Context, podName string, containerName string, path string, namespace string, token string, limit int) (*ArchiveListing, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stdout, err := DownloadSingleFile(ctx, podName, containerName, path, namespace, token)
+	if err != nil {
+		return nil, err
+	}
+	defer stdout.Close()
+
+	decompressor, compression, err := decompressReader(stdout.Reader())
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	listing := &ArchiveListing{Format: "tar", Entries: make([]ArchiveEntry, 0)}
+	for _, format := range compressionFormats {
+		if format.name == compression {
+			listing.Format += format.extension
+		}
+	}
+
+	// headers are read one after another, content of entries is skipped but still streamed up to the limit
+	archive := tar.NewReader(decompressor)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(listing.Entries) == 0 {
+				return nil, fmt.Errorf("Not a tar archive : %v", err)
+			}
+			return nil, err
+		}
+		if limit > 0 && len(listing.Entries) >= limit {
+			listing.Truncated = true
+			break
+		}
+		entry := newArchiveEntry(header.Name, header.Typeflag == tar.TypeDir, header.Size, header.ModTime, header.FileInfo().Mode().String())
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			entry.LinkTarget = header.Linkname
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+	return listing, nil
+}
+
+// ListArchive lists entries of a zip file (by extension, e.g. .zip, .jar), or a tar file which may be
+// compressed by gzip, bzip2, zstd or xz. At most `limit` entries are returned, reading a tar file ends when ctx is done
+func ListArchive(ctx context.Context, podName string, containerName string, path string, namespace string, token string, limit int) (*ArchiveListing, error) {
+	stat, err := GetFileStat(podName, containerName, path, namespace, token, "")
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir || stat.Size == nil {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+
+	if zipExtensions[strings.ToLower(filepath.Ext(path))] {
+		return listZipEntries(podName, containerName, path, namespace, token, *stat.Size, limit)
+	}
+	return listTarEntries(ctx, podName, containerName, path, namespace, token, limit)
+}
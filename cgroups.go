@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type CgroupMemory struct {
+	Current     int64            `json:"current"`
+	Max         *int64           `json:"max"`                   // null if unlimited
+	Peak        *int64           `json:"peak,omitempty"`        // highest usage, if the kernel tracks it
+	WorkingSet  int64            `json:"workingSet"`            // current minus inactive file cache, as kubelet evicts on
+	OomKills    int64            `json:"oomKills"`              // processes killed for reaching the limit
+	Events      map[string]int64 `json:"events"`                // memory.events, or oom_control and failcnt of v1
+	Stat        map[string]int64 `json:"stat"`                  // memory.stat
+	Pressure    *CgroupPressure  `json:"pressure,omitempty"`    // v2 only
+	SwapMax     *int64           `json:"swapMax,omitempty"`     // v2 only
+	SwapCurrent *int64           `json:"swapCurrent,omitempty"` // v2 only
+}
+
+type CgroupCpu struct {
+	UsageSeconds     float64         `json:"usageSeconds"`
+	UserSeconds      float64         `json:"userSeconds"`
+	SystemSeconds    float64         `json:"systemSeconds"`
+	Limit            *float64        `json:"limit"` // CPU cores of the quota, null if unlimited
+	Periods          int64           `json:"periods"`
+	ThrottledPeriods int64           `json:"throttledPeriods"`
+	ThrottledSeconds float64         `json:"throttledSeconds"`
+	ThrottledRatio   float64         `json:"throttledRatio"` // throttled periods / periods
+	Pressure         *CgroupPressure `json:"pressure,omitempty"`
+}
+
+type CgroupIoDevice struct {
+	Device     string `json:"device"` // major:minor
+	ReadBytes  int64  `json:"readBytes"`
+	WriteBytes int64  `json:"writeBytes"`
+	ReadOps    int64  `json:"readOps"`
+	WriteOps   int64  `json:"writeOps"`
+}
+
+type CgroupIo struct {
+	Devices  []CgroupIoDevice `json:"devices"`
+	Pressure *CgroupPressure  `json:"pressure,omitempty"`
+}
+
+type CgroupPids struct {
+	Current int64  `json:"current"`
+	Max     *int64 `json:"max"` // null if unlimited
+}
+
+// PressureStall is a line of pressure stall information, the share of time some (or all) tasks were stalled
+type PressureStall struct {
+	Avg10        float64 `json:"avg10"` // percentage over the last 10 seconds
+	Avg60        float64 `json:"avg60"`
+	Avg300       float64 `json:"avg300"`
+	TotalSeconds float64 `json:"totalSeconds"`
+}
+
+type CgroupPressure struct {
+	Some *PressureStall `json:"some,omitempty"`
+	Full *PressureStall `json:"full,omitempty"`
+}
+
+type CgroupResources struct {
+	Version int           `json:"version"` // 1 or 2
+	Memory  *CgroupMemory `json:"memory,omitempty"`
+	Cpu     *CgroupCpu    `json:"cpu,omitempty"`
+	Io      *CgroupIo     `json:"io,omitempty"`
+	Pids    *CgroupPids   `json:"pids,omitempty"`
+}
+
+const cgroupRoot = "/sys/fs/cgroup/"
+
+// files of the unified hierarchy, cgroup.controllers only exists in v2
+var cgroupV2Files = []string{
+	"cgroup.controllers",
+	"memory.current", "memory.max", "memory.peak", "memory.stat", "memory.events", "memory.pressure",
+	"memory.swap.current", "memory.swap.max",
+	"cpu.stat", "cpu.max", "cpu.pressure",
+	"io.stat", "io.pressure",
+	"pids.current", "pids.max",
+}
+
+// files of v1 hierarchies, one directory per controller
+var cgroupV1Files = []string{
+	"memory/memory.usage_in_bytes", "memory/memory.limit_in_bytes", "memory/memory.max_usage_in_bytes",
+	"memory/memory.failcnt", "memory/memory.stat", "memory/memory.oom_control",
+	"cpu/cpu.stat", "cpu/cpu.cfs_quota_us", "cpu/cpu.cfs_period_us",
+	"cpuacct/cpuacct.usage", "cpuacct/cpuacct.stat",
+	"blkio/blkio.throttle.io_service_bytes", "blkio/blkio.throttle.io_serviced",
+	"pids/pids.current", "pids/pids.max",
+}
+
+// v1 reports no limit as the largest multiple of the page size
+const cgroupV1Unlimited = int64(1) << 62
+
+// parseHeadFiles parses `head` of several files, each preceded by `==> path <==`
+func parseHeadFiles(buffer []byte) map[string]string {
+	files := make(map[string]string)
+	var path string
+	var content strings.Builder
+	flush := func() {
+		if len(path) > 0 {
+			files[path] = strings.TrimSpace(content.String())
+		}
+		content.Reset()
+	}
+	for _, line := range strings.Split(string(buffer), "\n") {
+		if strings.HasPrefix(line, "==> ") && strings.HasSuffix(line, " <==") {
+			flush()
+			path = line[4 : len(line)-4]
+			continue
+		}
+		content.WriteString(line)
+		content.WriteByte('\n')
+	}
+	flush()
+	return files
+}
+
+// parseCgroupLimit parses a limit, `max` or -1 being unlimited
+func parseCgroupLimit(value string) *int64 {
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 || limit >= cgroupV1Unlimited {
+		return nil
+	}
+	return &limit
+}
+
+// parseFlatKeyed parses lines of `key value`, as memory.stat or cpu.stat
+func parseFlatKeyed(content string) map[string]int64 {
+	values := make(map[string]int64)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values
+}
+
+// parsePressure parses `some avg10=0.00 avg60=0.00 avg300=0.00 total=0`, total being in microseconds
+func parsePressure(content string) *CgroupPressure {
+	var pressure CgroupPressure
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var stall PressureStall
+		for _, field := range fields[1:] {
+			pair := strings.SplitN(field, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseFloat(pair[1], 64)
+			switch pair[0] {
+			case "avg10":
+				stall.Avg10 = value
+			case "avg60":
+				stall.Avg60 = value
+			case "avg300":
+				stall.Avg300 = value
+			case "total":
+				stall.TotalSeconds = value / 1e6
+			}
+		}
+		switch fields[0] {
+		case "some":
+			pressure.Some = &stall
+		case "full":
+			pressure.Full = &stall
+		}
+	}
+	if pressure.Some == nil && pressure.Full == nil {
+		return nil
+	}
+	return &pressure
+}
+
+func parseCgroupValue(content string) int64 {
+	value, _ := strconv.ParseInt(strings.TrimSpace(content), 10, 64)
+	return value
+}
+
+func parseCgroupV2(files map[string]string) *CgroupResources {
+	resources := &CgroupResources{Version: 2}
+
+	if current, ok := files["memory.current"]; ok {
+		memory := &CgroupMemory{
+			Current:  parseCgroupValue(current),
+			Max:      parseCgroupLimit(files["memory.max"]),
+			Stat:     parseFlatKeyed(files["memory.stat"]),
+			Events:   parseFlatKeyed(files["memory.events"]),
+			Pressure: parsePressure(files["memory.pressure"]),
+		}
+		if peak, ok := files["memory.peak"]; ok {
+			value := parseCgroupValue(peak)
+			memory.Peak = &value
+		}
+		if swap, ok := files["memory.swap.current"]; ok {
+			value := parseCgroupValue(swap)
+			memory.SwapCurrent = &value
+			memory.SwapMax = parseCgroupLimit(files["memory.swap.max"])
+		}
+		memory.WorkingSet = memory.Current - memory.Stat["inactive_file"]
+		if memory.WorkingSet < 0 {
+			memory.WorkingSet = 0
+		}
+		memory.OomKills = memory.Events["oom_kill"]
+		resources.Memory = memory
+	}
+
+	if stat, ok := files["cpu.stat"]; ok {
+		values := parseFlatKeyed(stat)
+		cpu := &CgroupCpu{
+			UsageSeconds:     float64(values["usage_usec"]) / 1e6,
+			UserSeconds:      float64(values["user_usec"]) / 1e6,
+			SystemSeconds:    float64(values["system_usec"]) / 1e6,
+			Periods:          values["nr_periods"],
+			ThrottledPeriods: values["nr_throttled"],
+			ThrottledSeconds: float64(values["throttled_usec"]) / 1e6,
+			Pressure:         parsePressure(files["cpu.pressure"]),
+		}
+		// `max 100000` or `<quota> <period>` in microseconds
+		if fields := strings.Fields(files["cpu.max"]); len(fields) == 2 {
+			quota, quotaErr := strconv.ParseFloat(fields[0], 64)
+			period, periodErr := strconv.ParseFloat(fields[1], 64)
+			if quotaErr == nil && periodErr == nil && period > 0 {
+				limit := quota / period
+				cpu.Limit = &limit
+			}
+		}
+		resources.Cpu = cpu
+	}
+
+	if stat, ok := files["io.stat"]; ok {
+		io := &CgroupIo{Devices: make([]CgroupIoDevice, 0), Pressure: parsePressure(files["io.pressure"])}
+		// `8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0`
+		for _, line := range strings.Split(stat, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			device := CgroupIoDevice{Device: fields[0]}
+			for _, field := range fields[1:] {
+				pair := strings.SplitN(field, "=", 2)
+				if len(pair) != 2 {
+					continue
+				}
+				value, _ := strconv.ParseInt(pair[1], 10, 64)
+				switch pair[0] {
+				case "rbytes":
+					device.ReadBytes = value
+				case "wbytes":
+					device.WriteBytes = value
+				case "rios":
+					device.ReadOps = value
+				case "wios":
+					device.WriteOps = value
+				}
+			}
+			io.Devices = append(io.Devices, device)
+		}
+		resources.Io = io
+	}
+
+	if current, ok := files["pids.current"]; ok {
+		resources.Pids = &CgroupPids{Current: parseCgroupValue(current), Max: parseCgroupLimit(files["pids.max"])}
+	}
+	return resources
+}
+
+func parseCgroupV1(files map[string]string) *CgroupResources {
+	resources := &CgroupResources{Version: 1}
+
+	if usage, ok := files["memory/memory.usage_in_bytes"]; ok {
+		memory := &CgroupMemory{
+			Current: parseCgroupValue(usage),
+			Max:     parseCgroupLimit(files["memory/memory.limit_in_bytes"]),
+			Stat:    parseFlatKeyed(files["memory/memory.stat"]),
+			// oom_kill_disable, under_oom and oom_kill (since Linux 4.13)
+			Events: parseFlatKeyed(files["memory/memory.oom_control"]),
+		}
+		if peak, ok := files["memory/memory.max_usage_in_bytes"]; ok {
+			value := parseCgroupValue(peak)
+			memory.Peak = &value
+		}
+		// times the usage hit the limit, as `max` of memory.events in v2
+		memory.Events["failcnt"] = parseCgroupValue(files["memory/memory.failcnt"])
+		// hierarchical counter includes child cgroups, as usage does
+		memory.WorkingSet = memory.Current - memory.Stat["total_inactive_file"]
+		if memory.WorkingSet < 0 {
+			memory.WorkingSet = 0
+		}
+		memory.OomKills = memory.Events["oom_kill"]
+		resources.Memory = memory
+	}
+
+	_, hasUsage := files["cpuacct/cpuacct.usage"]
+	stat, hasStat := files["cpu/cpu.stat"]
+	if hasUsage || hasStat {
+		values := parseFlatKeyed(stat)
+		ticks := parseFlatKeyed(files["cpuacct/cpuacct.stat"])
+		cpu := &CgroupCpu{
+			UsageSeconds:     float64(parseCgroupValue(files["cpuacct/cpuacct.usage"])) / 1e9,
+			UserSeconds:      float64(ticks["user"]) / clockTicks,
+			SystemSeconds:    float64(ticks["system"]) / clockTicks,
+			Periods:          values["nr_periods"],
+			ThrottledPeriods: values["nr_throttled"],
+			ThrottledSeconds: float64(values["throttled_time"]) / 1e9,
+		}
+		quota, period := parseCgroupValue(files["cpu/cpu.cfs_quota_us"]), parseCgroupValue(files["cpu/cpu.cfs_period_us"])
+		if quota > 0 && period > 0 {
+			limit := float64(quota) / float64(period)
+			cpu.Limit = &limit
+		}
+		resources.Cpu = cpu
+	}
+
+	bytesFile, hasBytes := files["blkio/blkio.throttle.io_service_bytes"]
+	if hasBytes {
+		// `8:0 Read 123` lines per device and operation, then `Total 123`
+		devices := make(map[string]*CgroupIoDevice)
+		parse := func(content string, set func(device *CgroupIoDevice, operation string, value int64)) {
+			for _, line := range strings.Split(content, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) != 3 {
+					continue
+				}
+				device, ok := devices[fields[0]]
+				if !ok {
+					device = &CgroupIoDevice{Device: fields[0]}
+					devices[fields[0]] = device
+				}
+				value, _ := strconv.ParseInt(fields[2], 10, 64)
+				set(device, fields[1], value)
+			}
+		}
+		parse(bytesFile, func(device *CgroupIoDevice, operation string, value int64) {
+			switch operation {
+			case "Read":
+				device.ReadBytes = value
+			case "Write":
+				device.WriteBytes = value
+			}
+		})
+		parse(files["blkio/blkio.throttle.io_serviced"], func(device *CgroupIoDevice, operation string, value int64) {
+			switch operation {
+			case "Read":
+				device.ReadOps = value
+			case "Write":
+				device.WriteOps = value
+			}
+		})
+
+		io := &CgroupIo{Devices: make([]CgroupIoDevice, 0, len(devices))}
+		for _, device := range devices {
+			io.Devices = append(io.Devices, *device)
+		}
+		sort.Slice(io.Devices, func(i, j int) bool { return io.Devices[i].Device < io.Devices[j].Device })
+		resources.Io = io
+	}
+
+	if current, ok := files["pids/pids.current"]; ok {
+		resources.Pids = &CgroupPids{Current: parseCgroupValue(current), Max: parseCgroupLimit(files["pids/pids.max"])}
+	}
+	return resources
+}
+
+// GetCgroupResources reads usage, limits and pressure of the container from its cgroup mounted at /sys/fs/cgroup,
+// with either the unified hierarchy (v2) or v1 hierarchies. Unlike metrics-server, this tells CPU throttling
+// and OOM kills. Files of both versions are read by a single `head`, which fails on the missing ones
+func GetCgroupResources(podName string, containerName string, namespace string, token string) (*CgroupResources, error) {
+	cmd := []string{"head", "-n", "1000"}
+	for _, file := range cgroupV2Files {
+		cmd = append(cmd, cgroupRoot+file)
+	}
+	for _, file := range cgroupV1Files {
+		cmd = append(cmd, cgroupRoot+file)
+	}
+	var stdout bytes.Buffer
+	err := execCmdToWriter(context.Background(), podName, containerName, namespace, token, cmd, &stdout)
+
+	files := make(map[string]string)
+	for path, content := range parseHeadFiles(stdout.Bytes()) {
+		files[strings.TrimPrefix(path, cgroupRoot)] = content
+	}
+	if len(files) == 0 {
+		if err == nil {
+			err = fmt.Errorf("No output")
+		}
+		return nil, fmt.Errorf("Unable to read cgroup files in %s : %v", cgroupRoot, err)
+	}
+
+	var resources *CgroupResources
+	if _, ok := files["cgroup.controllers"]; ok {
+		resources = parseCgroupV2(files)
+	} else {
+		resources = parseCgroupV1(files)
+	}
+	if resources.Cpu != nil && resources.Cpu.Periods > 0 {
+		resources.Cpu.ThrottledRatio = float64(resources.Cpu.ThrottledPeriods) / float64(resources.Cpu.Periods)
+	}
+	return resources, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeadFiles(t *testing.T) {
+	output := "==> /sys/fs/cgroup/memory.max <==\nmax\n\n" +
+		"==> /sys/fs/cgroup/cpu.max <==\n100000 100000\n\n" +
+		"==> /sys/fs/cgroup/memory.stat <==\nanon 4096\nfile 8192\n"
+	expected := map[string]string{
+		"/sys/fs/cgroup/memory.max":  "max",
+		"/sys/fs/cgroup/cpu.max":     "100000 100000",
+		"/sys/fs/cgroup/memory.stat": "anon 4096\nfile 8192",
+	}
+	if files := parseHeadFiles([]byte(output)); !reflect.DeepEqual(files, expected) {
+		t.Errorf("got %q", files)
+	}
+	// lines before the first header are not attributed to any file
+	if files := parseHeadFiles([]byte("head: cannot open 'x'\n")); len(files) != 0 {
+		t.Errorf("got %q", files)
+	}
+}
+
+func TestParseCgroupLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		limit int64 // -1 if unlimited
+	}{
+		{"536870912", 536870912},
+		{"max", -1},
+		{"-1", -1},
+		{"9223372036854771712", -1},
+		{"0", 0},
+	}
+	for _, test := range tests {
+		limit := parseCgroupLimit(test.value)
+		if test.limit < 0 && limit != nil || test.limit >= 0 && (limit == nil || *limit != test.limit) {
+			t.Errorf("%s : got %v", test.value, limit)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// FileTarget locates a file or directory in a container
+type FileTarget struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Path      string `json:"path"`
+}
+
+func (target FileTarget) String() string {
+	return fmt.Sprintf("%s/%s:%s", target.Pod, target.Container, target.Path)
+}
+
+type FileDiffEntry struct {
+	Path    string    `json:"path"`   // relative to the compared directories
+	Change  string    `json:"change"` // added / removed / changed
+	Reasons []string  `json:"reasons,omitempty"`
+	Left    *FileInfo `json:"left,omitempty"`
+	Right   *FileInfo `json:"right,omitempty"`
+}
+
+type CompareResult struct {
+	Type      string          `json:"type"` // file / directory
+	Identical bool            `json:"identical"`
+	Diff      string          `json:"diff,omitempty"`      // unified diff of text files
+	Binary    bool            `json:"binary,omitempty"`    // files are compared by hash only if binary or too large
+	LeftHash  string          `json:"leftHash,omitempty"`  // sha256
+	RightHash string          `json:"rightHash,omitempty"` // sha256
+	Entries   []FileDiffEntry `json:"entries,omitempty"`   // differences of directories
+}
+
+// files larger than this are compared by hash instead of diff
+const maxCompareSize = 1024 * 1024
+
+// parallel runs the functions concurrently and returns the first error
+func parallel(fns ...func() error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(fns))
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn func() error) {
+			defer wg.Done()
+			errs[i] = fn()
+		}(i, fn)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listFilesRecursively lists files in the directory and its sub directories, by path relative to the directory
+func listFilesRecursively(target FileTarget, namespace string, token string) (map[string]FileInfo, error) {
+	cmd := []string{"ls", "-AlR", "--full-time", "--color=never", target.Path}
+	buffer, err := execCmd(target.Pod, target.Container, namespace, token, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	root := strings.TrimRight(target.Path, "/")
+	dir := root
+	files := make(map[string]FileInfo)
+
+	// /etc/nginx:
+	// total 4
+	// drwxr-xr-x    2 root     root          4096 2021-09-24 12:08:41 +0000 conf.d
+	scanner := bufio.NewScanner(bytes.NewReader(buffer))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fileinfo, ok := parseLsLine(line, dir); ok {
+			files[strings.TrimPrefix(fileinfo.Path, root+"/")] = *fileinfo
+		} else if strings.HasSuffix(line, ":") {
+			dir = strings.TrimSuffix(line, ":")
+		}
+	}
+	return files, nil
+}
+
+// modifyTimesRecursively returns modification times of files in the directory in seconds, by path relative to
+// the directory. `ls` only tells minutes, and in the time zone of the container
+func modifyTimesRecursively(target FileTarget, namespace string, token string) (map[string]int64, error) {
+	cmd := []string{"find", target.Path, "-mindepth", "1", "-exec", "stat", "-c", "%Y %n", "{}", "+"}
+	buffer, err := execCmd(target.Pod, target.Container, namespace, token, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	root := strings.TrimRight(target.Path, "/")
+	times := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(buffer))
+	for scanner.Scan() {
+		// 1632485280 /etc/nginx/conf.d/default.conf
+		columns := strings.SplitN(scanner.Text(), " ", 2)
+		if len(columns) != 2 {
+			continue
+		}
+		if modifyTime, err := strconv.ParseInt(columns[0], 10, 64); err == nil {
+			times[strings.TrimPrefix(columns[1], root+"/")] = modifyTime
+		}
+	}
+	return times, nil
+}
+
+// hashFilesRecursively computes sha256 of files in the directory, by path relative to the directory
+func hashFilesRecursively(target FileTarget, namespace string, token string) (map[string]string, error) {
+	cmd := []string{"find", target.Path, "-type", "f", "-exec", "sha256sum", "{}", "+"}
+	buffer, err := execCmd(target.Pod, target.Container, namespace, token, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	root := strings.TrimRight(target.Path, "/")
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(buffer))
+	for scanner.Scan() {
+		// e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  /etc/nginx/conf.d/empty
+		columns := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(columns) == 2 {
+			hashes[strings.TrimPrefix(columns[1], root+"/")] = columns[0]
+		}
+	}
+	return hashes, nil
+}
+
+func compareSingleFiles(left FileTarget, leftStat *FileStat, right FileTarget, rightStat *FileStat, namespace string, token string) (*CompareResult, error) {
+	result := &CompareResult{Type: "file"}
+
+	if leftStat.Size != nil && *leftStat.Size <= maxCompareSize && rightStat.Size != nil && *rightStat.Size <= maxCompareSize {
+		var leftContent, rightContent []byte
+		err := parallel(func() (err error) {
+			leftContent, err = execCmd(left.Pod, left.Container, namespace, token, []string{"cat", left.Path})
+			return
+		}, func() (err error) {
+			rightContent, err = execCmd(right.Pod, right.Container, namespace, token, []string{"cat", right.Path})
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		result.Identical = bytes.Equal(leftContent, rightContent)
+		result.Binary = bytes.IndexByte(leftContent, 0) >= 0 || bytes.IndexByte(rightContent, 0) >= 0
+		if !result.Binary {
+			result.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(string(leftContent)),
+				B:        difflib.SplitLines(string(rightContent)),
+				FromFile: left.String(),
+				ToFile:   right.String(),
+				Context:  3,
+			})
+			return result, err
+		}
+	}
+
+	// too large or binary
+	result.Binary = true
+	err := parallel(func() error {
+		stat, err := GetFileStat(left.Pod, left.Container, left.Path, namespace, token, "sha256")
+		if err == nil {
+			result.LeftHash = stat.Hash
+		}
+		return err
+	}, func() error {
+		stat, err := GetFileStat(right.Pod, right.Container, right.Path, namespace, token, "sha256")
+		if err == nil {
+			result.RightHash = stat.Hash
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Identical = result.LeftHash == result.RightHash
+	return result, nil
+}
+
+func compareDirectories(left FileTarget, right FileTarget, namespace string, token string, byTime bool, byHash bool) (*CompareResult, error) {
+	var leftFiles, rightFiles map[string]FileInfo
+	var leftHashes, rightHashes map[string]string
+	var leftTimes, rightTimes map[string]int64
+	fns := []func() error{
+		func() (err error) {
+			leftFiles, err = listFilesRecursively(left, namespace, token)
+			return
+		},
+		func() (err error) {
+			rightFiles, err = listFilesRecursively(right, namespace, token)
+			return
+		},
+	}
+	if byHash {
+		fns = append(fns, func() (err error) {
+			leftHashes, err = hashFilesRecursively(left, namespace, token)
+			return
+		}, func() (err error) {
+			rightHashes, err = hashFilesRecursively(right, namespace, token)
+			return
+		})
+	}
+	if byTime {
+		fns = append(fns, func() (err error) {
+			leftTimes, err = modifyTimesRecursively(left, namespace, token)
+			return
+		}, func() (err error) {
+			rightTimes, err = modifyTimesRecursively(right, namespace, token)
+			return
+		})
+	}
+	if err := parallel(fns...); err != nil {
+		return nil, err
+	}
+	for _, side := range []struct {
+		files map[string]FileInfo
+		times map[string]int64
+	}{{leftFiles, leftTimes}, {rightFiles, rightTimes}} {
+		for path, modifyTime := range side.times {
+			if file, ok := side.files[path]; ok {
+				file.Timestamp = modifyTime
+				file.Time = time.Unix(modifyTime, 0).UTC().Format("2006-01-02 15:04:05")
+				side.files[path] = file
+			}
+		}
+	}
+
+	result := &CompareResult{
+		Type:    "directory",
+		Entries: make([]FileDiffEntry, 0),
+	}
+	for path, leftFile := range leftFiles {
+		leftFile := leftFile
+		rightFile, ok := rightFiles[path]
+		if !ok {
+			result.Entries = append(result.Entries, FileDiffEntry{Path: path, Change: "removed", Left: &leftFile})
+			continue
+		}
+
+		var reasons []string
+		if leftFile.IsDir != rightFile.IsDir {
+			reasons = append(reasons, "type")
+		}
+		if (leftFile.Size == nil) != (rightFile.Size == nil) || (leftFile.Size != nil && *leftFile.Size != *rightFile.Size) {
+			reasons = append(reasons, "size")
+		}
+		if byTime && leftFile.Timestamp != rightFile.Timestamp {
+			reasons = append(reasons, "mtime")
+		}
+		if byHash && leftHashes[path] != rightHashes[path] {
+			reasons = append(reasons, "hash")
+		}
+		if len(reasons) > 0 {
+			result.Entries = append(result.Entries, FileDiffEntry{Path: path, Change: "changed", Reasons: reasons, Left: &leftFile, Right: &rightFile})
+		}
+	}
+	for path, rightFile := range rightFiles {
+		rightFile := rightFile
+		if _, ok := leftFiles[path]; !ok {
+			result.Entries = append(result.Entries, FileDiffEntry{Path: path, Change: "added", Right: &rightFile})
+		}
+	}
+
+	sort.Slice(result.Entries, func(i, j int) bool { return result.Entries[i].Path < result.Entries[j].Path })
+	result.Identical = len(result.Entries) == 0
+	return result, nil
+}
+
+// CompareFiles returns the unified diff of two files, or the differences of two directories recursively.
+// Directory entries are compared by size, and optionally by modification time in seconds (byTime) and sha256 (byHash)
+func CompareFiles(left FileTarget, right FileTarget, namespace string, token string, byTime bool, byHash bool) (*CompareResult, error) {
+	var leftStat, rightStat *FileStat
+	err := parallel(func() (err error) {
+		leftStat, err = GetFileStat(left.Pod, left.Container, left.Path, namespace, token, "")
+		return
+	}, func() (err error) {
+		rightStat, err = GetFileStat(right.Pod, right.Container, right.Path, namespace, token, "")
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if leftStat.IsDir && rightStat.IsDir {
+		return compareDirectories(left, right, namespace, token, byTime, byHash)
+	}
+	if leftStat.IsDir || rightStat.IsDir {
+		return nil, fmt.Errorf("Unable to compare a file with a directory")
+	}
+	return compareSingleFiles(left, leftStat, right, rightStat, namespace, token)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Features switches individual capabilities of the server on or off.
+// A disabled feature has no route registered at all.
+type Features struct {
+	FileList     bool `json:"fileList"`
+	FileView     bool `json:"fileView"`
+	FileDownload bool `json:"fileDownload"`
+	ProcessList  bool `json:"processList"`
+	FileWrite    bool `json:"fileWrite"`
+	Network      bool `json:"network"`   // sockets of the pod and their processes
+	Resources    bool `json:"resources"` // cgroup usage and pressure of containers
+}
+
+type Config struct {
+	Port     int      `json:"port"`
+	Username string   `json:"user"`
+	Password string   `json:"password"`
+	UIPath   string   `json:"uiPath"`
+	ReadOnly bool     `json:"readOnly"` // overrides every write feature
+	Features Features `json:"features"`
+
+	TLSCert     string `json:"tlsCert"`     // PEM certificate file, HTTPS is enabled when set
+	TLSKey      string `json:"tlsKey"`      // PEM private key file
+	TLSClientCA string `json:"tlsClientCA"` // PEM CA bundle to verify client certificates
+
+	ShutdownTimeout int `json:"shutdownTimeout"` // seconds to wait for in-flight requests on shutdown
+
+	MaxPreviewSize  int64 `json:"maxPreviewSize"`  // bytes of a file returned by view, larger files are truncated
+	SqliteCacheSize int64 `json:"sqliteCacheSize"` // bytes of SQLite databases copied to local disk at once
+
+	SampleInterval  int    `json:"sampleInterval"`  // seconds between metrics samples, 0 to disable history
+	SampleRetention int    `json:"sampleRetention"` // minutes of metrics history to keep
+	SampleNamespace string `json:"sampleNamespace"` // namespace to sample, all namespaces if empty
+}
+
+// environment variables are named after the flags, e.g. -ui-path => POD_INSPECTOR_UI_PATH
+const envPrefix = "POD_INSPECTOR_"
+
+func defaultConfig() Config {
+	return Config{
+		Port:            8080,
+		UIPath:          "./www/",
+		ShutdownTimeout: 30,
+		MaxPreviewSize:  10 * 1024 * 1024,
+		SqliteCacheSize: 64 * 1024 * 1024,
+		SampleInterval:  30,
+		SampleRetention: 60,
+		SampleNamespace: os.Getenv("POD_NAMESPACE"),
+		Features: Features{
+			FileList:     true,
+			FileView:     true,
+			FileDownload: true,
+			ProcessList:  true,
+			FileWrite:    false,
+			Network:      true,
+			Resources:    true,
+		},
+	}
+}
+
+func bindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "HTTP port to listen")
+	fs.StringVar(&cfg.Username, "user", cfg.Username, "Username to enable basic-authentication")
+	fs.StringVar(&cfg.Password, "password", cfg.Password, "Password to enable basic-authentication")
+	fs.StringVar(&cfg.UIPath, "ui-path", cfg.UIPath, "Path of static web sites")
+	fs.BoolVar(&cfg.ReadOnly, "read-only", cfg.ReadOnly, "Disable all features modifying containers")
+	fs.BoolVar(&cfg.Features.FileList, "file-list", cfg.Features.FileList, "Enable listing files")
+	fs.BoolVar(&cfg.Features.FileView, "file-view", cfg.Features.FileView, "Enable viewing files")
+	fs.BoolVar(&cfg.Features.FileDownload, "file-download", cfg.Features.FileDownload, "Enable downloading files")
+	fs.BoolVar(&cfg.Features.ProcessList, "process-list", cfg.Features.ProcessList, "Enable listing processes")
+	fs.BoolVar(&cfg.Features.FileWrite, "file-write", cfg.Features.FileWrite, "Enable modifying files")
+	fs.BoolVar(&cfg.Features.Network, "network", cfg.Features.Network, "Enable listing network connections")
+	fs.BoolVar(&cfg.Features.Resources, "resources", cfg.Features.Resources, "Enable reading resource usage and pressure of containers")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "Path of TLS certificate file to enable HTTPS")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "Path of TLS private key file")
+	fs.IntVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "Seconds to wait for in-flight requests on shutdown")
+	fs.Int64Var(&cfg.MaxPreviewSize, "max-preview-size", cfg.MaxPreviewSize, "Bytes of a file returned by view, larger files are truncated")
+	fs.Int64Var(&cfg.SqliteCacheSize, "sqlite-cache-size", cfg.SqliteCacheSize, "Bytes of SQLite databases copied to local disk at once, 0 to disable SQLite browsing")
+	fs.IntVar(&cfg.SampleInterval, "sample-interval", cfg.SampleInterval, "Seconds between metrics samples, 0 to disable metrics history")
+	fs.IntVar(&cfg.SampleRetention, "sample-retention", cfg.SampleRetention, "Minutes of metrics history to keep")
+	fs.StringVar(&cfg.SampleNamespace, "sample-namespace", cfg.SampleNamespace, "Namespace to sample metrics, all namespaces if empty")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, "Path of CA bundle to require and verify client certificates")
+}
+
+// LoadServerConfig builds the configuration from (lowest priority first)
+// built-in defaults, the config file, environment variables and command line flags
+func LoadServerConfig(args []string) (*Config, error) {
+	var configFile string
+
+	// parse flags into a scratch config, only the explicitly set ones are applied at the end
+	scratch := defaultConfig()
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	fs.StringVar(&configFile, "config", os.Getenv(envPrefix+"CONFIG"), "Path of YAML or JSON config file")
+	bindFlags(fs, &scratch)
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if len(configFile) > 0 {
+		content, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(content, &cfg); err != nil {
+			return nil, fmt.Errorf("Unable to parse config file %s : %v", configFile, err)
+		}
+	}
+
+	target := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	bindFlags(target, &cfg)
+
+	var err error
+	target.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && err == nil {
+			if e := target.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("Invalid value of %s : %v", name, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if target.Lookup(f.Name) != nil && err == nil {
+			if e := target.Set(f.Name, f.Value.String()); e != nil {
+				err = fmt.Errorf("Invalid value of -%s : %v", f.Name, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// TLSEnabled tells if the server listens on HTTPS
+func (cfg *Config) TLSEnabled() bool {
+	return len(cfg.TLSCert) > 0 || len(cfg.TLSKey) > 0 || len(cfg.TLSClientCA) > 0
+}
+
+// Capabilities returns the effective features after applying read-only mode
+func (cfg *Config) Capabilities() Features {
+	features := cfg.Features
+	if cfg.ReadOnly {
+		features.FileWrite = false
+	}
+	return features
+}
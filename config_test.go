@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadServerConfigPrecedence(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := "port: 9000\nuiPath: /file/\nuser: file\nfeatures:\n  fileWrite: true\n"
+	if err := ioutil.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		args      []string
+		port      int
+		uiPath    string
+		user      string
+		fileWrite bool
+	}{
+		{"defaults", nil, nil, 8080, "./www/", "", false},
+		{"file", nil, []string{"-config", configFile}, 9000, "/file/", "file", true},
+		{"config file from env", map[string]string{"POD_INSPECTOR_CONFIG": configFile}, nil, 9000, "/file/", "file", true},
+		{"env over file", map[string]string{"POD_INSPECTOR_PORT": "9001", "POD_INSPECTOR_UI_PATH": "/env/"},
+			[]string{"-config", configFile}, 9001, "/env/", "file", true},
+		{"flags over env", map[string]string{"POD_INSPECTOR_PORT": "9001", "POD_INSPECTOR_UI_PATH": "/env/"},
+			[]string{"-config", configFile, "-port", "9002", "-file-write=false"}, 9002, "/env/", "file", false},
+		{"flag equal to default still applies", map[string]string{"POD_INSPECTOR_PORT": "9001"},
+			[]string{"-port", "8080"}, 8080, "./www/", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := LoadServerConfig(append([]string{"pod-inspector"}, test.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Port != test.port || cfg.UIPath != test.uiPath || cfg.Username != test.user || cfg.Features.FileWrite != test.fileWrite {
+				t.Errorf("got port %d, uiPath %s, user %s, fileWrite %v", cfg.Port, cfg.UIPath, cfg.Username, cfg.Features.FileWrite)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfigInvalidEnv(t *testing.T) {
+	t.Setenv("POD_INSPECTOR_PORT", "http")
+	if _, err := LoadServerConfig([]string{"pod-inspector"}); err == nil {
+		t.Error("expected an error for an invalid port")
+	}
+}
+
+func TestCapabilitiesReadOnly(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Features.FileWrite = true
+	cfg.ReadOnly = true
+	if cfg.Capabilities().FileWrite {
+		t.Error("read-only mode must disable fileWrite")
+	}
+}
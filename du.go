@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DiskUsageNode struct {
+	FileInfo
+	Files    int64            `json:"files"` // number of files within the directory, recursively
+	Children []*DiskUsageNode `json:"children,omitempty"`
+}
+
+type DiskUsageProgress struct {
+	Entries int64  `json:"entries"` // number of files and directories scanned so far
+	Path    string `json:"path"`    // latest scanned path
+}
+
+// interval between progress reports while `du` is running
+const diskUsageProgressInterval = 500 * time.Millisecond
+
+// diskUsageTree aggregates `du -a` output into a tree limited to maxDepth levels below the root
+type diskUsageTree struct {
+	root      string
+	maxDepth  int
+	nodes     map[string]*DiskUsageNode // relative path => node, within maxDepth
+	openDirs  map[string]bool           // directories with entries seen, but not the directory itself yet
+	fileCount map[string]int64          // relative path => number of files, within maxDepth
+}
+
+func newDiskUsageTree(root string, maxDepth int) *diskUsageTree {
+	root = strings.TrimRight(root, "/")
+	return &diskUsageTree{
+		root:      root,
+		maxDepth:  maxDepth,
+		nodes:     make(map[string]*DiskUsageNode),
+		openDirs:  make(map[string]bool),
+		fileCount: make(map[string]int64),
+	}
+}
+
+func parentPath(rel string) string {
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		return rel[:i]
+	}
+	return ""
+}
+
+func (self *diskUsageTree) node(rel string) *DiskUsageNode {
+	if node, ok := self.nodes[rel]; ok {
+		return node
+	}
+	name := rel[strings.LastIndex(rel, "/")+1:]
+	if len(rel) == 0 {
+		name = self.root[strings.LastIndex(self.root, "/")+1:]
+	}
+	node := &DiskUsageNode{
+		FileInfo: FileInfo{
+			Name: name,
+			Path: self.root + "/" + rel,
+		},
+	}
+	if len(rel) == 0 {
+		node.Path = self.root
+		if len(node.Path) == 0 {
+			node.Path = "/"
+		}
+	}
+	self.nodes[rel] = node
+	if len(rel) > 0 {
+		parent := self.node(parentPath(rel))
+		parent.IsDir = true
+		parent.Children = append(parent.Children, node)
+	}
+	return node
+}
+
+// add processes one entry of `du -a` output. `du` prints entries of a directory before the directory itself,
+// so an entry is known to be a directory if entries within it were printed before
+func (self *diskUsageTree) add(size int64, path string) {
+	rel := strings.TrimPrefix(strings.TrimPrefix(path, self.root), "/")
+	depth := 0
+	if len(rel) > 0 {
+		depth = strings.Count(rel, "/") + 1
+	}
+
+	isDir := self.openDirs[rel]
+	delete(self.openDirs, rel)
+	if len(rel) > 0 {
+		self.openDirs[parentPath(rel)] = true
+	}
+
+	if !isDir {
+		// count the file in every ancestor kept in the tree
+		ancestor := rel
+		for d := depth; d >= 0; d-- {
+			if d <= self.maxDepth && d < depth {
+				self.fileCount[ancestor]++
+			}
+			ancestor = parentPath(ancestor)
+		}
+	}
+
+	if depth <= self.maxDepth {
+		node := self.node(rel)
+		node.IsDir = node.IsDir || isDir
+		node.Size = &size
+	}
+}
+
+// result sorts children by size and keeps the largest `limit` children of each directory
+func (self *diskUsageTree) result(limit int) *DiskUsageNode {
+	for rel, node := range self.nodes {
+		node.Files = self.fileCount[rel]
+		if node.Size == nil {
+			var zero int64
+			node.Size = &zero
+		}
+	}
+	for _, node := range self.nodes {
+		sort.SliceStable(node.Children, func(i, j int) bool {
+			return *node.Children[i].Size > *node.Children[j].Size
+		})
+		if limit > 0 && len(node.Children) > limit {
+			node.Children = node.Children[:limit]
+		}
+	}
+	return self.node("")
+}
+
+// GetDiskUsage runs `du` on the path and aggregates the sizes into a tree of maxDepth levels,
+// keeping the largest `limit` entries of each directory. `progress` is called periodically while `du` runs,
+// which is ended when ctx is done
+func GetDiskUsage(ctx context.Context, podName string, containerName string, path string, namespace string, token string, maxDepth int, limit int, progress func(DiskUsageProgress)) (*DiskUsageNode, error) {
+	// -a all files, -k sizes in KB, -x stay on one file system; supported by BusyBox and coreutils.
+	// depth is limited on the server, otherwise files below the depth would not be counted
+	cmd := []string{"du", "-akx", path}
+	stdout, err := execCmdToChannel(ctx, podName, containerName, namespace, token, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer stdout.Close()
+
+	tree := newDiskUsageTree(path, maxDepth)
+	var entries int64
+	reportedAt := time.Now()
+
+	// 5242	/var/log/nginx
+	scanner := bufio.NewScanner(stdout.Reader())
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			fmt.Println("Unable to parse `du` response :", line)
+			continue
+		}
+		size, err := strconv.ParseInt(line[:tab], 10, 64)
+		if err != nil {
+			fmt.Println("Unable to parse `du` response :", line)
+			continue
+		}
+		tree.add(size*1024, line[tab+1:])
+
+		entries++
+		if progress != nil && time.Since(reportedAt) >= diskUsageProgressInterval {
+			reportedAt = time.Now()
+			progress(DiskUsageProgress{Entries: entries, Path: line[tab+1:]})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// `du` exits with non-zero code when some entries are not readable, the rest is still reported
+	if err := scanner.Err(); err != nil && entries == 0 {
+		return nil, err
+	}
+	return tree.result(limit), nil
+}
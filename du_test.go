@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestDiskUsageTree(t *testing.T) {
+	// `du -a` prints entries of a directory before the directory itself
+	tree := newDiskUsageTree("/var/", 1)
+	tree.add(4, "/var/log/a.log")
+	tree.add(8, "/var/log/sub/b.log")
+	tree.add(12, "/var/log/sub")
+	tree.add(20, "/var/log")
+	tree.add(1, "/var/x")
+	tree.add(2, "/var/y")
+	tree.add(0, "/var/empty")
+	tree.add(30, "/var")
+
+	root := tree.result(2)
+	if root.Name != "var" || root.Path != "/var" || !root.IsDir || *root.Size != 30 || root.Files != 5 {
+		t.Errorf("got root %+v", root)
+	}
+	if len(root.Children) != 2 {
+		t.Fatalf("got %d children", len(root.Children))
+	}
+	log, y := root.Children[0], root.Children[1]
+	if log.Name != "log" || log.Path != "/var/log" || !log.IsDir || *log.Size != 20 || log.Files != 2 || len(log.Children) != 0 {
+		t.Errorf("got %+v", log)
+	}
+	if y.Name != "y" || y.IsDir || *y.Size != 2 || y.Files != 0 {
+		t.Errorf("got %+v", y)
+	}
+
+	tree = newDiskUsageTree("/", 0)
+	tree.add(4, "/a")
+	tree.add(10, "/")
+	if root := tree.result(0); root.Path != "/" || *root.Size != 10 || root.Files != 1 || len(root.Children) != 0 {
+		t.Errorf("got %+v", root)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// WritePrecondition is the state of the file when the client read it, the file is replaced only if it is unchanged
+type WritePrecondition struct {
+	ModifyTime *int64 // unix timestamp
+	Size       *int64 // in bytes
+	Hash       string // sha256
+	Create     bool   // the file must not exist
+}
+
+// FileConflictError is returned when the file has changed since the client read it
+type FileConflictError struct {
+	Message string
+	Current *FileStat // nil if the file did not exist when created
+}
+
+func (self *FileConflictError) Error() string {
+	return self.Message
+}
+
+// check returns a conflict error if the current state of the file does not match the precondition
+func (self *WritePrecondition) check(stat *FileStat) error {
+	if self.ModifyTime != nil && *self.ModifyTime != stat.ModifyTime {
+		return &FileConflictError{Message: "File has been modified since it was read", Current: stat}
+	}
+	if self.Size != nil && (stat.Size == nil || *self.Size != *stat.Size) {
+		return &FileConflictError{Message: "File size has changed since it was read", Current: stat}
+	}
+	if len(self.Hash) > 0 && !strings.EqualFold(self.Hash, stat.Hash) {
+		return &FileConflictError{Message: "File content has changed since it was read", Current: stat}
+	}
+	return nil
+}
+
+// tempFilePath returns a hidden file name next to the path, so that moving it over the path is atomic
+func tempFilePath(path string) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	dir, name := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		dir, name = path[:i+1], path[i+1:]
+	}
+	return fmt.Sprintf("%s.%s.%s.tmp", dir, name, hex.EncodeToString(random)), nil
+}
+
+// checkWritePrecondition returns the current stat of the file if it matches the precondition, nil when it is created.
+// A file deleted since it was read is a conflict, and so is a file which is no longer a regular one when checked again
+// before it is replaced; directories, links and devices are refused otherwise
+func checkWritePrecondition(podName string, containerName string, path string, namespace string, token string, precondition WritePrecondition, recheck bool) (*FileStat, error) {
+	if precondition.Create {
+		exists, err := fileExists(podName, containerName, namespace, token, path)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, &FileConflictError{Message: "File already exists"}
+		}
+		return nil, nil
+	}
+
+	hashType := ""
+	if len(precondition.Hash) > 0 {
+		hashType = "sha256"
+	}
+	stat, err := GetFileStat(podName, containerName, path, namespace, token, hashType)
+	if err != nil {
+		if opErr, ok := newFileOperationError("write", path, err).(*FileOperationError); ok && opErr.Reason == "notFound" {
+			return nil, &FileConflictError{Message: "File has been deleted since it was read"}
+		}
+		return nil, err
+	}
+	if stat.Type != "regular file" {
+		if recheck {
+			return nil, &FileConflictError{Message: "File has been replaced by a " + stat.Type + " since it was read", Current: stat}
+		}
+		// replacing a symbolic link would turn it into a file, e.g. keys of config maps are links
+		reason := "invalid"
+		if stat.IsDir {
+			reason = "isDirectory"
+		}
+		return nil, &FileOperationError{Operation: "write", Path: path, Reason: reason, ExitCode: -1,
+			Message: fmt.Sprintf("Unable to write %s which is a %s", path, stat.Type)}
+	}
+	if err := precondition.check(stat); err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+// WriteFileContent replaces the content of the file if it matches the precondition. The content is written
+// to a temp file in the same directory, which gets the mode and owner of the file, then moved over the file
+// once the precondition is checked again.
+// Returns the stat of the new file with its sha256
+func WriteFileContent(podName string, containerName string, path string, namespace string, token string, precondition WritePrecondition, content []byte) (*FileStat, error) {
+	if err := checkPath("write", path); err != nil {
+		return nil, err
+	}
+
+	stat, err := checkWritePrecondition(podName, containerName, path, namespace, token, precondition, false)
+	if err != nil {
+		return nil, err
+	}
+
+	tempFile, err := tempFilePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// `dd` writes stdin to the file without a shell, both BusyBox and coreutils have it
+	cmd := []string{"dd", "of=" + tempFile, "bs=65536"}
+	if _, err := execCmdWithStdin(context.Background(), podName, containerName, namespace, token, cmd, bytes.NewReader(content)); err != nil {
+		execCmd(podName, containerName, namespace, token, []string{"rm", "-f", "--", tempFile})
+		return nil, newFileOperationError("write", path, err)
+	}
+
+	var commands [][]string
+	if stat != nil {
+		commands = append(commands, []string{"chmod", stat.Mode, "--", tempFile})
+		// changing owner fails for non-root users, who own the files they create anyway
+		commands = append(commands, []string{"chown", strconv.FormatInt(stat.Uid, 10) + ":" + strconv.FormatInt(stat.Gid, 10), "--", tempFile})
+	}
+	for _, cmd := range commands {
+		if _, err := execCmd(podName, containerName, namespace, token, cmd); err != nil && cmd[0] != "chown" {
+			execCmd(podName, containerName, namespace, token, []string{"rm", "-f", "--", tempFile})
+			return nil, newFileOperationError("write", path, err)
+		}
+	}
+
+	// the file may have changed while the content was uploaded, it is checked again right before it is replaced
+	if _, err := checkWritePrecondition(podName, containerName, path, namespace, token, precondition, true); err != nil {
+		execCmd(podName, containerName, namespace, token, []string{"rm", "-f", "--", tempFile})
+		return nil, err
+	}
+	if _, err := execCmd(podName, containerName, namespace, token, []string{"mv", "-f", "--", tempFile, path}); err != nil {
+		execCmd(podName, containerName, namespace, token, []string{"rm", "-f", "--", tempFile})
+		return nil, newFileOperationError("write", path, err)
+	}
+
+	return GetFileStat(podName, containerName, path, namespace, token, "sha256")
+}
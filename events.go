@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+type K8sEvent struct {
+	Namespace      string `json:"namespace"`
+	Kind           string `json:"kind"` // kind of involved object
+	Name           string `json:"name"` // name of involved object
+	Type           string `json:"type"` // Normal / Warning
+	Reason         string `json:"reason"`
+	Message        string `json:"message"`
+	Source         string `json:"source"`
+	Count          int32  `json:"count"`
+	FirstTimestamp int64  `json:"firstTimestamp"` // unix timestamp
+	LastTimestamp  int64  `json:"lastTimestamp"`  // unix timestamp
+}
+
+// EventFilter selects events by the involved object and event type, empty fields match all
+type EventFilter struct {
+	Kind string
+	Name string
+	Type string
+}
+
+// field selector of events.k8s.io/v1 (useCore = false) or core/v1 (useCore = true)
+func (filter EventFilter) selector(useCore bool) string {
+	prefix := "regarding."
+	if useCore {
+		prefix = "involvedObject."
+	}
+	set := fields.Set{}
+	if len(filter.Kind) > 0 {
+		set[prefix+"kind"] = filter.Kind
+	}
+	if len(filter.Name) > 0 {
+		set[prefix+"name"] = filter.Name
+	}
+	if len(filter.Type) > 0 {
+		set["type"] = filter.Type
+	}
+	return fields.SelectorFromSet(set).String()
+}
+
+func newK8sEvent(obj interface{}) (K8sEvent, bool) {
+	switch event := obj.(type) {
+	case *eventsv1.Event:
+		result := K8sEvent{
+			Namespace:      event.Namespace,
+			Kind:           event.Regarding.Kind,
+			Name:           event.Regarding.Name,
+			Type:           event.Type,
+			Reason:         event.Reason,
+			Message:        event.Note,
+			Source:         event.ReportingController,
+			Count:          event.DeprecatedCount,
+			FirstTimestamp: event.EventTime.Unix(),
+			LastTimestamp:  event.DeprecatedLastTimestamp.Unix(),
+		}
+		if event.EventTime.IsZero() {
+			result.FirstTimestamp = event.DeprecatedFirstTimestamp.Unix()
+		}
+		if len(result.Source) == 0 {
+			result.Source = event.DeprecatedSource.Component
+		}
+		if event.Series != nil {
+			result.Count = event.Series.Count
+			result.LastTimestamp = event.Series.LastObservedTime.Unix()
+		}
+		if event.DeprecatedLastTimestamp.IsZero() && event.Series == nil {
+			result.LastTimestamp = result.FirstTimestamp
+		}
+		if result.Count < 1 {
+			result.Count = 1
+		}
+		return result, true
+
+	case *corev1.Event:
+		result := K8sEvent{
+			Namespace:      event.Namespace,
+			Kind:           event.InvolvedObject.Kind,
+			Name:           event.InvolvedObject.Name,
+			Type:           event.Type,
+			Reason:         event.Reason,
+			Message:        event.Message,
+			Source:         event.Source.Component,
+			Count:          event.Count,
+			FirstTimestamp: event.FirstTimestamp.Unix(),
+			LastTimestamp:  event.LastTimestamp.Unix(),
+		}
+		if event.FirstTimestamp.IsZero() {
+			result.FirstTimestamp = event.EventTime.Unix()
+		}
+		if event.LastTimestamp.IsZero() {
+			result.LastTimestamp = result.FirstTimestamp
+		}
+		if len(result.Source) == 0 {
+			result.Source = event.ReportingController
+		}
+		if event.Series != nil {
+			result.Count = event.Series.Count
+			result.LastTimestamp = event.Series.LastObservedTime.Unix()
+		}
+		if result.Count < 1 {
+			result.Count = 1
+		}
+		return result, true
+	}
+	return K8sEvent{}, false
+}
+
+// events.k8s.io/v1 is not served by old clusters, and might not be granted by RBAC
+func fallbackToCoreEvents(err error) bool {
+	return apierrors.IsNotFound(err) || apierrors.IsForbidden(err)
+}
+
+// listEvents lists events from events.k8s.io/v1, or core/v1 if the former is unavailable
+func listEvents(ctx context.Context, client *k8sClient, namespace string, filter EventFilter) ([]K8sEvent, error) {
+	var events []K8sEvent
+
+	el, err := client.clientset.EventsV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: filter.selector(false),
+	})
+	if err == nil {
+		for i := range el.Items {
+			if event, ok := newK8sEvent(&el.Items[i]); ok {
+				events = append(events, event)
+			}
+		}
+		return events, nil
+	}
+	if !fallbackToCoreEvents(err) {
+		return nil, countApiError("events.list", err)
+	}
+
+	cel, err := client.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: filter.selector(true),
+	})
+	if err != nil {
+		return nil, countApiError("events.list", err)
+	}
+	for i := range cel.Items {
+		if event, ok := newK8sEvent(&cel.Items[i]); ok {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func watchEvents(ctx context.Context, client *k8sClient, namespace string, filter EventFilter) (watch.Interface, error) {
+	watcher, err := client.clientset.EventsV1().Events(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: filter.selector(false),
+	})
+	if err == nil {
+		return watcher, nil
+	}
+	if !fallbackToCoreEvents(err) {
+		return nil, countApiError("events.watch", err)
+	}
+
+	watcher, err = client.clientset.CoreV1().Events(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: filter.selector(true),
+	})
+	return watcher, countApiError("events.watch", err)
+}
+
+// podEventFilters selects events of the pod and its owners, e.g. ReplicaSet and Deployment
+func podEventFilters(ctx context.Context, client *k8sClient, podName string, namespace string, eventType string) ([]EventFilter, error) {
+	pod, err := client.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, countApiError("pods.get", err)
+	}
+
+	filters := []EventFilter{{Kind: "Pod", Name: pod.Name, Type: eventType}}
+	for _, owner := range getOwners(pod) {
+		filters = append(filters, EventFilter{Kind: owner.Kind, Name: owner.Name, Type: eventType})
+	}
+	return filters, nil
+}
+
+func sortEvents(events []K8sEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTimestamp < events[j].LastTimestamp
+	})
+}
+
+// GetEvents lists events in the namespace, ordered by last seen
+func GetEvents(namespace string, token string, filter EventFilter) ([]K8sEvent, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+	events, err := listEvents(context.TODO(), client, namespace, filter)
+	if err != nil {
+		return nil, err
+	}
+	sortEvents(events)
+	return events, nil
+}
+
+// GetPodEvents lists events of the pod and its owner chain, ordered by last seen
+func GetPodEvents(podName string, namespace string, token string, eventType string) ([]K8sEvent, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := podEventFilters(context.TODO(), client, podName, namespace, eventType)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]K8sEvent, 0)
+	for _, filter := range filters {
+		matched, err := listEvents(context.TODO(), client, namespace, filter)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, matched...)
+	}
+	sortEvents(events)
+	return events, nil
+}
+
+// WatchEvents streams events matching any of the filters until ctx is cancelled, the channel is closed then
+func WatchEvents(ctx context.Context, namespace string, token string, filters []EventFilter) (<-chan K8sEvent, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	watchers := make([]watch.Interface, 0, len(filters))
+	for _, filter := range filters {
+		watcher, err := watchEvents(ctx, client, namespace, filter)
+		if err != nil {
+			for _, w := range watchers {
+				w.Stop()
+			}
+			return nil, err
+		}
+		watchers = append(watchers, watcher)
+	}
+
+	events := make(chan K8sEvent, 100)
+	done := make(chan struct{}, len(watchers))
+	for _, watcher := range watchers {
+		go func(watcher watch.Interface) {
+			defer func() { done <- struct{}{} }()
+			defer watcher.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case result, ok := <-watcher.ResultChan():
+					if !ok {
+						return
+					}
+					if result.Type != watch.Added && result.Type != watch.Modified {
+						continue
+					}
+					if event, ok := newK8sEvent(result.Object); ok {
+						select {
+						case events <- event:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+			}
+		}(watcher)
+	}
+
+	go func() {
+		for range watchers {
+			<-done
+		}
+		close(events)
+	}()
+	return events, nil
+}
+
+// WatchPodEvents streams events of the pod and its owner chain
+func WatchPodEvents(ctx context.Context, podName string, namespace string, token string, eventType string) (<-chan K8sEvent, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := podEventFilters(ctx, client, podName, namespace, eventType)
+	if err != nil {
+		return nil, err
+	}
+	return WatchEvents(ctx, namespace, token, filters)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// FileOperationError tells why a command modifying files failed, from its error output
+type FileOperationError struct {
+	Operation string `json:"operation"` // mkdir / move / copy / delete / chmod / chown / write
+	Path      string `json:"path"`
+	Reason    string `json:"reason"`   // notFound / permissionDenied / exists / notEmpty / readOnly / noSpace / isDirectory / notDirectory / invalid / failed
+	ExitCode  int    `json:"exitCode"` // -1 if the command did not run to completion
+	Message   string `json:"error"`
+}
+
+func (self *FileOperationError) Error() string {
+	return self.Message
+}
+
+// StatusCode maps the reason to the HTTP status returned to clients
+func (self *FileOperationError) StatusCode() int {
+	switch self.Reason {
+	case "notFound":
+		return http.StatusNotFound
+	case "permissionDenied", "readOnly":
+		return http.StatusForbidden
+	case "exists", "notEmpty":
+		return http.StatusConflict
+	case "isDirectory", "notDirectory", "invalid":
+		return http.StatusBadRequest
+	case "noSpace":
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// error messages of BusyBox and coreutils are the same, they come from strerror()
+var fileErrorReasons = []struct {
+	message string
+	reason  string
+}{
+	{"no such file", "notFound"},
+	{"permission denied", "permissionDenied"},
+	{"operation not permitted", "permissionDenied"},
+	{"read-only file system", "readOnly"},
+	{"file exists", "exists"},
+	{"directory not empty", "notEmpty"},
+	{"no space left", "noSpace"},
+	{"disk quota exceeded", "noSpace"},
+	{"is a directory", "isDirectory"},
+	{"not a directory", "notDirectory"},
+}
+
+func newFileOperationError(operation string, path string, err error) error {
+	result := &FileOperationError{
+		Operation: operation,
+		Path:      path,
+		Reason:    "failed",
+		ExitCode:  -1,
+		Message:   err.Error(),
+	}
+	if execErr, ok := err.(*ExecError); ok {
+		result.ExitCode = execErr.ExitCode
+		stderr := strings.ToLower(execErr.Stderr)
+		for _, item := range fileErrorReasons {
+			if strings.Contains(stderr, item.message) {
+				result.Reason = item.reason
+				break
+			}
+		}
+		if len(execErr.Stderr) > 0 {
+			result.Message = execErr.Stderr
+		}
+	}
+	return result
+}
+
+func invalidFileOperation(operation string, path string, format string, args ...interface{}) error {
+	return &FileOperationError{
+		Operation: operation,
+		Path:      path,
+		Reason:    "invalid",
+		ExitCode:  -1,
+		Message:   fmt.Sprintf(format, args...),
+	}
+}
+
+// runFileCommand runs a command modifying files and converts its failure to FileOperationError.
+// Commands are given as argv, paths are placed after `--` so that they are never taken as options
+func runFileCommand(podName string, containerName string, namespace string, token string, operation string, path string, cmd []string) error {
+	if _, err := execCmd(podName, containerName, namespace, token, cmd); err != nil {
+		return newFileOperationError(operation, path, err)
+	}
+	return nil
+}
+
+// checkPath rejects empty and relative paths, and the root which no operation should apply to
+func checkPath(operation string, path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return invalidFileOperation(operation, path, "Path must be absolute")
+	}
+	if len(strings.Trim(path, "/")) == 0 {
+		return invalidFileOperation(operation, path, "Unable to %s the root directory", operation)
+	}
+	return nil
+}
+
+// fileExists runs `test -e`, which exits with 1 if the path does not exist. Other failures, e.g. the exec
+// being denied, are returned as errors rather than taken for a missing file
+func fileExists(podName string, containerName string, namespace string, token string, path string) (bool, error) {
+	_, err := execCmd(podName, containerName, namespace, token, []string{"test", "-e", path})
+	if err == nil {
+		return true, nil
+	}
+	if execErr, ok := err.(*ExecError); ok && execErr.ExitCode == 1 {
+		return false, nil
+	}
+	return false, err
+}
+
+// checkTarget fails if the target exists, unless it is to be overwritten
+func checkTarget(podName string, containerName string, namespace string, token string, operation string, target string, overwrite bool) error {
+	if err := checkPath(operation, target); err != nil {
+		return err
+	}
+	if !overwrite {
+		exists, err := fileExists(podName, containerName, namespace, token, target)
+		if err != nil {
+			return newFileOperationError(operation, target, err)
+		}
+		if exists {
+			return &FileOperationError{Operation: operation, Path: target, Reason: "exists", ExitCode: -1, Message: target + " already exists"}
+		}
+	}
+	return nil
+}
+
+// MakeDirectory creates the directory, and its missing parents if `parents` is set
+func MakeDirectory(podName string, containerName string, path string, namespace string, token string, parents bool) (*FileStat, error) {
+	if err := checkPath("mkdir", path); err != nil {
+		return nil, err
+	}
+	cmd := []string{"mkdir"}
+	if parents {
+		cmd = append(cmd, "-p")
+	}
+	cmd = append(cmd, "--", path)
+	if err := runFileCommand(podName, containerName, namespace, token, "mkdir", path, cmd); err != nil {
+		return nil, err
+	}
+	return GetFileStat(podName, containerName, path, namespace, token, "")
+}
+
+// MoveFile renames or moves the file or directory to the target path. An existing target fails the move,
+// unless `overwrite` is set; in which case a file is replaced, and a directory gets the source moved into it
+func MoveFile(podName string, containerName string, path string, target string, namespace string, token string, overwrite bool) (*FileStat, error) {
+	if err := checkPath("move", path); err != nil {
+		return nil, err
+	}
+	if err := checkTarget(podName, containerName, namespace, token, "move", target, overwrite); err != nil {
+		return nil, err
+	}
+	cmd := []string{"mv", "-f", "--", path, target}
+	if err := runFileCommand(podName, containerName, namespace, token, "move", path, cmd); err != nil {
+		return nil, err
+	}
+	return GetFileStat(podName, containerName, target, namespace, token, "")
+}
+
+// CopyFile copies the file or directory recursively to the target path, preserving mode, owner and times.
+// An existing target is handled as MoveFile does
+func CopyFile(podName string, containerName string, path string, target string, namespace string, token string, overwrite bool) (*FileStat, error) {
+	if err := checkPath("copy", path); err != nil {
+		return nil, err
+	}
+	if err := checkTarget(podName, containerName, namespace, token, "copy", target, overwrite); err != nil {
+		return nil, err
+	}
+	cmd := []string{"cp", "-a", "--", path, target}
+	if err := runFileCommand(podName, containerName, namespace, token, "copy", path, cmd); err != nil {
+		return nil, err
+	}
+	return GetFileStat(podName, containerName, target, namespace, token, "")
+}
+
+// DeleteFile deletes the file, or the directory if it is empty. Directories are deleted with their content
+// only if `recursive` is set and `confirm` repeats the path, so that a wrong path is not wiped out by accident
+func DeleteFile(podName string, containerName string, path string, namespace string, token string, recursive bool, confirm string) error {
+	if err := checkPath("delete", path); err != nil {
+		return err
+	}
+	if recursive && confirm != path {
+		return invalidFileOperation("delete", path, "Deleting recursively must be confirmed by the path")
+	}
+
+	stat, err := GetFileStat(podName, containerName, path, namespace, token, "")
+	if err != nil {
+		return newFileOperationError("delete", path, err)
+	}
+
+	var cmd []string
+	switch {
+	case !stat.IsDir:
+		cmd = []string{"rm", "-f", "--", path}
+	case recursive:
+		cmd = []string{"rm", "-rf", "--", path}
+	default:
+		// fails with `notEmpty` if the directory has content
+		cmd = []string{"rmdir", "--", path}
+	}
+	return runFileCommand(podName, containerName, namespace, token, "delete", path, cmd)
+}
+
+// octal (755) or symbolic (u+x,go-w) modes
+var modeRegex = regexp.MustCompile("^([0-7]{3,4}|[ugoa]*[-+=][rwxXst]*(,[ugoa]*[-+=][rwxXst]*)*)$")
+
+// user and group names or ids
+var ownerRegex = regexp.MustCompile("^[A-Za-z0-9_][A-Za-z0-9._-]*$")
+
+// ChangeMode sets the mode of the file, or the directory and its content if `recursive` is set
+func ChangeMode(podName string, containerName string, path string, namespace string, token string, mode string, recursive bool) (*FileStat, error) {
+	if err := checkPath("chmod", path); err != nil {
+		return nil, err
+	}
+	// a mode starting with `-` would be taken as an option, `a-w` is the same as `-w`
+	if !modeRegex.MatchString(mode) || strings.HasPrefix(mode, "-") {
+		return nil, invalidFileOperation("chmod", path, "Invalid mode %s", mode)
+	}
+	cmd := []string{"chmod"}
+	if recursive {
+		cmd = append(cmd, "-R")
+	}
+	cmd = append(cmd, mode, "--", path)
+	if err := runFileCommand(podName, containerName, namespace, token, "chmod", path, cmd); err != nil {
+		return nil, err
+	}
+	return GetFileStat(podName, containerName, path, namespace, token, "")
+}
+
+// ChangeOwner sets the owner and/or group of the file, or the directory and its content if `recursive` is set
+func ChangeOwner(podName string, containerName string, path string, namespace string, token string, owner string, group string, recursive bool) (*FileStat, error) {
+	if err := checkPath("chown", path); err != nil {
+		return nil, err
+	}
+	if len(owner) == 0 && len(group) == 0 {
+		return nil, invalidFileOperation("chown", path, "Owner or group is required")
+	}
+	for _, name := range []string{owner, group} {
+		if len(name) > 0 && !ownerRegex.MatchString(name) {
+			return nil, invalidFileOperation("chown", path, "Invalid user or group %s", name)
+		}
+	}
+	ownership := owner
+	if len(group) > 0 {
+		ownership += ":" + group
+	}
+	cmd := []string{"chown"}
+	if recursive {
+		cmd = append(cmd, "-R")
+	}
+	cmd = append(cmd, ownership, "--", path)
+	if err := runFileCommand(podName, containerName, namespace, token, "chown", path, cmd); err != nil {
+		return nil, err
+	}
+	return GetFileStat(podName, containerName, path, namespace, token, "")
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,12 +13,13 @@ import (
 )
 
 type FileInfo struct {
-	Name      string `json:"name"`
-	Path      string `json:"path"`
-	IsDir     bool   `json:"isDir"`
-	Size      *int64 `json:"size,omitempty"` // in bytes
-	Time      string `json:"time"`
-	Timestamp int64  `json:"timestamp"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	IsDir      bool   `json:"isDir"`
+	Size       *int64 `json:"size,omitempty"` // in bytes
+	Time       string `json:"time"`
+	Timestamp  int64  `json:"timestamp"`
+	LinkTarget string `json:"linkTarget,omitempty"` // of symbolic links
 }
 
 // drwxrwxrwt   1 root   root  4096 2021-09-24 12:08 tmp
@@ -36,62 +39,214 @@ func GetFiles(podName string, containerName string, path string, namespace strin
 	scanner := bufio.NewScanner(bytes.NewReader(buffer))
 	for scanner.Scan() {
 		line := scanner.Text()
-		matchedStrings := timeRegex.FindStringSubmatch(line)
+		if fileinfo, ok := parseLsLine(line, path); ok {
+			files = append(files, *fileinfo)
+		} else {
+			fmt.Println("Unable to parse `ls` response :", line)
+		}
+	}
 
-		if len(matchedStrings) > 7 {
-			mp := make(map[string]string)
-			for i, name := range timeRegex.SubexpNames() {
-				if i != 0 && name != "" {
-					mp[name] = matchedStrings[i]
-				}
-			}
+	return files, nil
+}
 
-			path = strings.TrimRight(path, "\\/")
-			fileinfo := FileInfo{
-				Name: mp["filename"],
-				Path: fmt.Sprintf("%s/%s", path, mp["filename"]),
-				Time: fmt.Sprintf("%s-%s-%s %s:%s", mp["year"], mp["month"], mp["day"], mp["hour"], mp["minute"]),
-			}
+// parseLsLine parses a line of `ls -l --full-time` listing the directory `dir`
+func parseLsLine(line string, dir string) (*FileInfo, bool) {
+	matchedStrings := timeRegex.FindStringSubmatch(line)
+	if len(matchedStrings) <= 7 {
+		return nil, false
+	}
 
-			year, _ := strconv.Atoi(mp["year"])
-			month, _ := strconv.Atoi(mp["month"])
-			day, _ := strconv.Atoi(mp["day"])
-			hour, _ := strconv.Atoi(mp["hour"])
-			minute, _ := strconv.Atoi(mp["minute"])
-
-			datetime := time.Date(year, time.Month(month), day, hour, minute, 0, 0, time.UTC)
-			fileinfo.Timestamp = datetime.Unix()
-
-			splittedStrings := spaceRegex.Split(mp["other"], -1)
-			if len(splittedStrings) > 3 {
-				if strings.HasPrefix(splittedStrings[0], "d") {
-					fileinfo.IsDir = true
-				} else {
-					size, err := strconv.ParseInt(splittedStrings[len(splittedStrings)-1], 10, 64)
-					if err == nil {
-						fileinfo.Size = &size
-					}
-				}
-			}
+	mp := make(map[string]string)
+	for i, name := range timeRegex.SubexpNames() {
+		if i != 0 && name != "" {
+			mp[name] = matchedStrings[i]
+		}
+	}
+
+	dir = strings.TrimRight(dir, "\\/")
+	fileinfo := FileInfo{
+		Name: mp["filename"],
+		Path: fmt.Sprintf("%s/%s", dir, mp["filename"]),
+		Time: fmt.Sprintf("%s-%s-%s %s:%s", mp["year"], mp["month"], mp["day"], mp["hour"], mp["minute"]),
+	}
+
+	year, _ := strconv.Atoi(mp["year"])
+	month, _ := strconv.Atoi(mp["month"])
+	day, _ := strconv.Atoi(mp["day"])
+	hour, _ := strconv.Atoi(mp["hour"])
+	minute, _ := strconv.Atoi(mp["minute"])
 
-			files = append(files, fileinfo)
+	datetime := time.Date(year, time.Month(month), day, hour, minute, 0, 0, time.UTC)
+	fileinfo.Timestamp = datetime.Unix()
+
+	splittedStrings := spaceRegex.Split(mp["other"], -1)
+	if len(splittedStrings) > 3 {
+		if strings.HasPrefix(splittedStrings[0], "d") {
+			fileinfo.IsDir = true
 		} else {
-			fmt.Println("Unable to parse `ls` response :", line)
+			// symbolic links not dereferenced are listed as `name -> target`
+			if strings.HasPrefix(splittedStrings[0], "l") {
+				if i := strings.Index(fileinfo.Name, " -> "); i > 0 {
+					fileinfo.LinkTarget = fileinfo.Name[i+4:]
+					fileinfo.Name = fileinfo.Name[:i]
+					fileinfo.Path = fmt.Sprintf("%s/%s", dir, fileinfo.Name)
+				}
+			}
+			size, err := strconv.ParseInt(splittedStrings[len(splittedStrings)-1], 10, 64)
+			if err == nil {
+				fileinfo.Size = &size
+			}
 		}
-
 	}
 
-	return files, nil
+	return &fileinfo, true
 }
 
-func DownloadSingleFile(podName string, containerName string, path string, namespace string, token string) (*StdoutChannel, error) {
+// DownloadSingleFile streams the file, `cat` is ended when ctx is done
+func DownloadSingleFile(ctx context.Context, podName string, containerName string, path string, namespace string, token string) (*StdoutChannel, error) {
 
 	cmd := []string{"cat", path}
-	return execCmdToChannel(podName, containerName, namespace, token, cmd)
+	return execCmdToChannel(ctx, podName, containerName, namespace, token, cmd)
 }
 
-func GetFileContent(podName string, containerName string, path string, namespace string, token string) (string, error) {
+// GetFilePreview streams the first maxSize bytes of the file, `head` is ended when ctx is done
+func GetFilePreview(ctx context.Context, podName string, containerName string, path string, namespace string, token string, maxSize int64) (*StdoutChannel, error) {
 
-	cmd := []string{"cat", path}
-	return execCmdToFile(podName, containerName, namespace, token, cmd)
+	cmd := []string{"head", "-c", strconv.FormatInt(maxSize, 10), path}
+	return execCmdToChannel(ctx, podName, containerName, namespace, token, cmd)
+}
+
+// truncatingReader reads the first maxSize bytes, and tells whether there is more content once they are read
+type truncatingReader struct {
+	reader  io.Reader
+	limited *io.LimitedReader
+}
+
+func newTruncatingReader(reader io.Reader, maxSize int64) *truncatingReader {
+	return &truncatingReader{reader: reader, limited: &io.LimitedReader{R: reader, N: maxSize}}
+}
+
+func (self *truncatingReader) Read(p []byte) (int, error) {
+	return self.limited.Read(p)
+}
+
+// Truncated reads one byte past the limit, once the first maxSize bytes are read
+func (self *truncatingReader) Truncated() bool {
+	if self.limited.N > 0 {
+		return false
+	}
+	var one [1]byte
+	n, _ := io.ReadFull(self.reader, one[:])
+	return n > 0
+}
+
+type FileStat struct {
+	FileInfo
+	Type        string `json:"type"` // regular file, directory, symbolic link...
+	Inode       uint64 `json:"inode"`
+	Mode        string `json:"mode"`        // octal, e.g. 644
+	Permissions string `json:"permissions"` // e.g. -rw-r--r--
+	Uid         int64  `json:"uid"`
+	Gid         int64  `json:"gid"`
+	User        string `json:"user"`
+	Group       string `json:"group"`
+	Links       int64  `json:"links"`
+	AccessTime  int64  `json:"accessTime"` // unix timestamp
+	ModifyTime  int64  `json:"modifyTime"` // unix timestamp
+	ChangeTime  int64  `json:"changeTime"` // unix timestamp
+	Hash        string `json:"hash,omitempty"`
+	HashType    string `json:"hashType,omitempty"` // sha256 / md5
+}
+
+// format of `stat` supported by both BusyBox and coreutils, %N is the last since file names may contain the separator
+const statFormat = "%i|%a|%A|%u|%g|%U|%G|%h|%s|%X|%Y|%Z|%F|%N"
+
+var hashCommands = map[string]string{
+	"sha256": "sha256sum",
+	"md5":    "md5sum",
+}
+
+// 'link' -> 'target'
+var linkRegex = regexp.MustCompile("^.+ -> ['\"`]?(?P<target>.*?)['\"]?$")
+
+// GetFileStat returns metadata of the file, and its hash if hashType (sha256 or md5) is not empty
+func GetFileStat(podName string, containerName string, path string, namespace string, token string, hashType string) (*FileStat, error) {
+	var hashCommand string
+	if len(hashType) > 0 {
+		var ok bool
+		if hashCommand, ok = hashCommands[hashType]; !ok {
+			return nil, fmt.Errorf("Unsupported hash %s", hashType)
+		}
+	}
+
+	cmd := []string{"stat", "-c", statFormat, "--", path}
+	buffer, err := execCmd(podName, containerName, namespace, token, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := parseStatOutput(path, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hashCommand) > 0 && !stat.IsDir {
+		cmd := []string{hashCommand, "--", stat.Path}
+		buffer, err := execCmd(podName, containerName, namespace, token, cmd)
+		if err != nil {
+			return nil, err
+		}
+		// e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  /tmp/empty
+		if columns := spaceRegex.Split(strings.TrimSpace(string(buffer)), 2); len(columns[0]) > 0 {
+			stat.Hash = columns[0]
+			stat.HashType = hashType
+		}
+	}
+
+	return stat, nil
+}
+
+// parseStatOutput parses the output of `stat -c statFormat` of the path
+func parseStatOutput(path string, buffer []byte) (*FileStat, error) {
+	// 1048602|644|-rw-r--r--|0|0|root|root|1|1024|1632485280|1632485280|1632485280|regular file|'/etc/hosts'
+	fields := strings.SplitN(strings.TrimRight(string(buffer), "\r\n"), "|", 14)
+	if len(fields) < 14 {
+		return nil, fmt.Errorf("Unable to parse `stat` response : %s", string(buffer))
+	}
+
+	path = strings.TrimRight(path, "\\/")
+	if len(path) == 0 {
+		path = "/"
+	}
+	stat := FileStat{
+		FileInfo: FileInfo{
+			Name:  path[strings.LastIndex(path, "/")+1:],
+			Path:  path,
+			IsDir: fields[12] == "directory",
+		},
+		Type:        fields[12],
+		Mode:        fields[1],
+		Permissions: fields[2],
+		User:        fields[5],
+		Group:       fields[6],
+	}
+	stat.Inode, _ = strconv.ParseUint(fields[0], 10, 64)
+	stat.Uid, _ = strconv.ParseInt(fields[3], 10, 64)
+	stat.Gid, _ = strconv.ParseInt(fields[4], 10, 64)
+	stat.Links, _ = strconv.ParseInt(fields[7], 10, 64)
+	stat.AccessTime, _ = strconv.ParseInt(fields[9], 10, 64)
+	stat.ModifyTime, _ = strconv.ParseInt(fields[10], 10, 64)
+	stat.ChangeTime, _ = strconv.ParseInt(fields[11], 10, 64)
+	if size, err := strconv.ParseInt(fields[8], 10, 64); err == nil && !stat.IsDir {
+		stat.Size = &size
+	}
+	stat.Timestamp = stat.ModifyTime
+	stat.Time = time.Unix(stat.ModifyTime, 0).UTC().Format("2006-01-02 15:04:05")
+
+	if stat.Type == "symbolic link" {
+		if matchedStrings := linkRegex.FindStringSubmatch(fields[13]); len(matchedStrings) > 1 {
+			stat.LinkTarget = matchedStrings[1]
+		}
+	}
+	return &stat, nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+func TestParseStatOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		output     string
+		fileName   string
+		fileType   string
+		size       int64 // -1 if unknown
+		linkTarget string
+	}{
+		{"regular file", "/etc/hostname",
+			"288|755|-rwxr-xr-x|0|0|root|root|1|3|1792114035|1792382189|1792382189|regular file|'/etc/hostname'\n",
+			"hostname", "regular file", 3, ""},
+		{"directory has no size", "/var/log/",
+			"2|755|drwxr-xr-x|0|0|root|root|20|4096|1792382189|1792382192|1792382192|directory|'/var/log/'\r\n",
+			"log", "directory", -1, ""},
+		{"root", "/",
+			"2|755|drwxr-xr-x|0|0|root|root|20|4096|1792382189|1792382192|1792382192|directory|'/'\n",
+			"", "directory", -1, ""},
+		{"link whose name contains the separator", "/tmp/li|nk",
+			"9623876|777|lrwxrwxrwx|0|0|root|root|1|11|1792387981|1792387981|1792387981|symbolic link|'/tmp/li|nk' -> '/etc/ho sts'\n",
+			"li|nk", "symbolic link", 11, "/etc/ho sts"},
+		{"link of BusyBox", "/etc/localtime",
+			"131|777|lrwxrwxrwx|0|0|root|root|1|27|1792387981|1792387981|1792387981|symbolic link|'/etc/localtime' -> '/usr/share/zoneinfo/UTC'\n",
+			"localtime", "symbolic link", 27, "/usr/share/zoneinfo/UTC"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stat, err := parseStatOutput(test.path, []byte(test.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Name != test.fileName || stat.Type != test.fileType || stat.LinkTarget != test.linkTarget {
+				t.Errorf("got name %q, type %q, link target %q", stat.Name, stat.Type, stat.LinkTarget)
+			}
+			if test.size < 0 && stat.Size != nil || test.size >= 0 && (stat.Size == nil || *stat.Size != test.size) {
+				t.Errorf("got size %v, expecting %d", stat.Size, test.size)
+			}
+			if stat.IsDir != (test.fileType == "directory") {
+				t.Errorf("got isDir %v", stat.IsDir)
+			}
+		})
+	}
+
+	stat, _ := parseStatOutput("/etc/hostname", []byte(tests[0].output))
+	if stat.Mode != "755" || stat.Permissions != "-rwxr-xr-x" || stat.Inode != 288 || stat.Links != 1 ||
+		stat.ModifyTime != 1792382189 || stat.Timestamp != stat.ModifyTime || stat.Time != "2026-10-19 03:56:29" {
+		t.Errorf("got %+v", stat)
+	}
+
+	if _, err := parseStatOutput("/etc/hostname", []byte("stat: unrecognized option\n")); err == nil {
+		t.Error("expected an error for unexpected output")
+	}
+}
+
+func TestParseLsLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		name       string
+		path       string
+		isDir      bool
+		size       int64 // -1 if unknown
+		linkTarget string
+	}{
+		{"-rw-r--r--    1 root     root           3 2026-10-19 03:56:29.000000000 +0000 hostname",
+			"hostname", "/etc/hostname", false, 3, ""},
+		{"drwxr-xr-x 2 root root 4096 2026-10-19 03:56:32.123456789 +0000 my dir",
+			"my dir", "/etc/my dir", true, -1, ""},
+		{"lrwxrwxrwx 1 root root 27 2026-10-19 03:56:29.000000000 +0000 localtime -> /usr/share/zoneinfo/UTC",
+			"localtime", "/etc/localtime", false, 27, "/usr/share/zoneinfo/UTC"},
+	}
+	for _, test := range tests {
+		fileinfo, ok := parseLsLine(test.line, "/etc/")
+		if !ok {
+			t.Errorf("%s : not parsed", test.line)
+			continue
+		}
+		if fileinfo.Name != test.name || fileinfo.Path != test.path || fileinfo.IsDir != test.isDir || fileinfo.LinkTarget != test.linkTarget {
+			t.Errorf("%s : got %+v", test.line, fileinfo)
+		}
+		if test.size < 0 && fileinfo.Size != nil || test.size >= 0 && (fileinfo.Size == nil || *fileinfo.Size != test.size) {
+			t.Errorf("%s : got size %v", test.line, fileinfo.Size)
+		}
+		if fileinfo.Time != "2026-10-19 03:56" || fileinfo.Timestamp != 1792382160 {
+			t.Errorf("%s : got time %s, %d", test.line, fileinfo.Time, fileinfo.Timestamp)
+		}
+	}
+
+	if _, ok := parseLsLine("total 8", "/etc"); ok {
+		t.Error("expected the total line to be skipped")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// set once the server begins to shut down, so that readiness fails and no new traffic is routed here
+var shuttingDown int32
+
+// cancelled once the server begins to shut down, to end long-lived streams such as watches
+var serverContext, stopServerContext = context.WithCancel(context.Background())
+
+// timeout of the API server call of each readiness probe
+const readinessTimeout = 5 * time.Second
+
+// CheckReadiness verifies the kube config can be loaded and the API server is reachable.
+// The cached client is used, so that probes neither load the config nor log about it every time
+func CheckReadiness(ctx context.Context) error {
+	client, err := getClient("")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	_, err = client.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	return err
+}
+
+func getHealthz(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+func getReadyz(c *gin.Context) {
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		c.String(http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	if err := CheckReadiness(c.Request.Context()); err != nil {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type MetricsSample struct {
+	Timestamp int64 `json:"timestamp"` // unix timestamp
+	CpuUsage  int64 `json:"cpuUsage"`  // 1 Core = 1000 milli
+	RamUsage  int64 `json:"ramUsage"`  // KB
+}
+
+// sampleRing keeps the latest samples of one container or node, the oldest is overwritten when full
+type sampleRing struct {
+	samples  []MetricsSample
+	next     int
+	full     bool
+	lastSeen time.Time
+}
+
+func (self *sampleRing) add(sample MetricsSample) {
+	// metrics-server refreshes less often than it may be polled
+	last := (self.next + len(self.samples) - 1) % len(self.samples)
+	if (self.full || self.next > 0) && self.samples[last].Timestamp == sample.Timestamp {
+		return
+	}
+	self.samples[self.next] = sample
+	self.next = (self.next + 1) % len(self.samples)
+	if self.next == 0 {
+		self.full = true
+	}
+}
+
+// since returns samples not older than the timestamp, in chronological order
+func (self *sampleRing) since(timestamp int64) []MetricsSample {
+	var ordered []MetricsSample
+	if self.full {
+		ordered = append(ordered, self.samples[self.next:]...)
+	}
+	ordered = append(ordered, self.samples[:self.next]...)
+
+	result := make([]MetricsSample, 0, len(ordered))
+	for _, sample := range ordered {
+		if sample.Timestamp >= timestamp {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
+
+// MetricsHistory is an in-memory time series of pod and node metrics
+type MetricsHistory struct {
+	mutex      sync.RWMutex
+	capacity   int
+	retention  time.Duration
+	containers map[string]*sampleRing // namespace/pod/container => samples
+	nodes      map[string]*sampleRing // node => samples
+}
+
+var metricsHistory *MetricsHistory
+
+func NewMetricsHistory(interval time.Duration, retention time.Duration) *MetricsHistory {
+	capacity := int(retention / interval)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MetricsHistory{
+		capacity:   capacity,
+		retention:  retention,
+		containers: make(map[string]*sampleRing),
+		nodes:      make(map[string]*sampleRing),
+	}
+}
+
+func (self *MetricsHistory) add(series map[string]*sampleRing, key string, sample MetricsSample, now time.Time) {
+	ring, ok := series[key]
+	if !ok {
+		ring = &sampleRing{samples: make([]MetricsSample, self.capacity)}
+		series[key] = ring
+	}
+	ring.lastSeen = now
+	ring.add(sample)
+}
+
+// evict drops series which have not been sampled within retention, e.g. deleted pods
+func (self *MetricsHistory) evict(now time.Time) {
+	for _, series := range []map[string]*sampleRing{self.containers, self.nodes} {
+		for key, ring := range series {
+			if now.Sub(ring.lastSeen) > self.retention {
+				delete(series, key)
+			}
+		}
+	}
+}
+
+func (self *MetricsHistory) sample(ctx context.Context, namespace string) error {
+	client, err := getClient("")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	pml, err := client.metrics.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return countApiError("podmetrics.list", err)
+	}
+	// node metrics require cluster-wide permission, pods are still sampled without it
+	nml, nodeErr := client.metrics.MetricsV1beta1().NodeMetricses().List(ctx, metav1.ListOptions{})
+	countApiError("nodemetrics.list", nodeErr)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for _, item := range pml.Items {
+		for _, c := range item.Containers {
+			self.add(self.containers, item.Namespace+"/"+item.Name+"/"+c.Name, MetricsSample{
+				Timestamp: item.Timestamp.Unix(),
+				CpuUsage:  c.Usage.Cpu().ScaledValue(resource.Milli),
+				RamUsage:  c.Usage.Memory().ScaledValue(resource.Kilo),
+			}, now)
+		}
+	}
+	if nodeErr == nil {
+		for _, item := range nml.Items {
+			self.add(self.nodes, item.Name, MetricsSample{
+				Timestamp: item.Timestamp.Unix(),
+				CpuUsage:  item.Usage.Cpu().ScaledValue(resource.Milli),
+				RamUsage:  item.Usage.Memory().ScaledValue(resource.Kilo),
+			}, now)
+		}
+	}
+	self.evict(now)
+	return nil
+}
+
+// Run samples metrics of pods in the namespace (all namespaces if empty) until ctx is cancelled
+func (self *MetricsHistory) Run(ctx context.Context, namespace string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := self.sample(ctx, namespace); err != nil && ctx.Err() == nil {
+			fmt.Println("Unable to sample metrics :", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PodHistory returns samples of each container of the pod within the window
+func (self *MetricsHistory) PodHistory(namespace string, podName string, window time.Duration) map[string][]MetricsSample {
+	prefix := namespace + "/" + podName + "/"
+	since := time.Now().Add(-window).Unix()
+
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	result := make(map[string][]MetricsSample)
+	for key, ring := range self.containers {
+		if strings.HasPrefix(key, prefix) {
+			result[strings.TrimPrefix(key, prefix)] = ring.since(since)
+		}
+	}
+	return result
+}
+
+// NodeHistory returns samples of the node within the window
+func (self *MetricsHistory) NodeHistory(nodeName string, window time.Duration) []MetricsSample {
+	since := time.Now().Add(-window).Unix()
+
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	if ring, ok := self.nodes[nodeName]; ok {
+		return ring.since(since)
+	}
+	return []MetricsSample{}
+}
+
+// GetPodMetricsHistory returns sampled metrics of the pod if it is accessible with the token
+func GetPodMetricsHistory(podName string, namespace string, token string, window time.Duration) (map[string][]MetricsSample, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// samples are collected with the server's credential, make sure the caller is allowed to see the pod
+	pod, err := client.clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, countApiError("pods.get", err)
+	}
+	return metricsHistory.PodHistory(pod.Namespace, pod.Name, window), nil
+}
+
+// GetNodeMetricsHistory returns sampled metrics of the node if it is accessible with the token
+func GetNodeMetricsHistory(nodeName string, token string, window time.Duration) ([]MetricsSample, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := client.clientset.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, countApiError("nodes.get", err)
+	}
+	return metricsHistory.NodeHistory(node.Name, window), nil
+}
@@ -2,24 +2,32 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	_ "k8s.io/apimachinery/pkg/api/errors"
 	_ "k8s.io/apimachinery/pkg/api/resource"
 	_ "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	utilexec "k8s.io/client-go/util/exec"
 	"k8s.io/client-go/util/homedir"
-	_ "k8s.io/metrics/pkg/client/clientset/versioned"
+	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
 	//
 	// Uncomment to load all auth plugins
 	// _ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -37,7 +45,7 @@ func loadConfig(token string) (*rest.Config, error) {
 	if home := homedir.HomeDir(); home != "" {
 		kubeconfig = filepath.Join(home, ".kube", "config")
 		if _, err := os.Stat(kubeconfig); os.IsNotExist(err) {
-			fmt.Println("Local config file %s does not exist", kubeconfig)
+			fmt.Printf("Local config file %s does not exist\n", kubeconfig)
 		} else {
 			config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 			if err == nil {
@@ -59,6 +67,70 @@ func loadConfig(token string) (*rest.Config, error) {
 	return nil, errors.New(fmt.Sprintf("Failed to load config from %s; Failed to load in-cluster config", kubeconfig))
 }
 
+// clients are cached by token, so that connections to API server are reused across requests
+const clientCacheTTL = 10 * time.Minute
+const clientCacheSize = 64
+
+type k8sClient struct {
+	config    *rest.Config
+	clientset *kubernetes.Clientset
+	metrics   *metrics.Clientset
+	createdAt time.Time
+}
+
+var clientCache = struct {
+	sync.Mutex
+	clients map[string]*k8sClient
+}{clients: make(map[string]*k8sClient)}
+
+func getClient(token string) (*k8sClient, error) {
+	clientCache.Lock()
+	defer clientCache.Unlock()
+
+	if client, ok := clientCache.clients[token]; ok {
+		if time.Since(client.createdAt) < clientCacheTTL {
+			clientCacheTotal.WithLabelValues("hit").Inc()
+			return client, nil
+		}
+		delete(clientCache.clients, token)
+	}
+	clientCacheTotal.WithLabelValues("miss").Inc()
+
+	config, err := loadConfig(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the clientset
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// metrics clientset
+	mc, err := metrics.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(clientCache.clients) >= clientCacheSize {
+		for key, client := range clientCache.clients {
+			if time.Since(client.createdAt) >= clientCacheTTL || len(clientCache.clients) >= clientCacheSize {
+				delete(clientCache.clients, key)
+			}
+		}
+	}
+
+	client := &k8sClient{
+		config:    config,
+		clientset: clientset,
+		metrics:   mc,
+		createdAt: time.Now(),
+	}
+	clientCache.clients[token] = client
+	return client, nil
+}
+
 // sizeQueue implements remotecommand.TerminalSizeQueue
 type fixedTerminalSizeQueue struct {
 }
@@ -74,17 +146,66 @@ func (s *fixedTerminalSizeQueue) Next() *remotecommand.TerminalSize {
 	return &size
 }
 
-func execCmd(podName string, containerName string, namespace string, token string, cmd []string) ([]byte, error) {
-	config, err := loadConfig(token)
-	if err != nil {
-		return nil, err
+// ExecError is a command exiting with non-zero code, or failing to run in the container
+type ExecError struct {
+	Err      error
+	ExitCode int    // -1 if the command did not run to completion
+	Stderr   string // error output of the command, which tells the reason, e.g. permission denied
+}
+
+// error output kept in ExecError
+const maxExecErrorOutput = 1024
+
+func (self *ExecError) Error() string {
+	if len(self.Stderr) > 0 {
+		return fmt.Sprintf("%v: %s", self.Err, self.Stderr)
 	}
+	return self.Err.Error()
+}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+func newExecError(err error, stderr *bytes.Buffer) error {
+	result := &ExecError{Err: err, ExitCode: -1}
+	if exitErr, ok := err.(utilexec.ExitError); ok {
+		result.ExitCode = exitErr.ExitStatus()
+	}
+	output := stderr.Bytes()
+	if len(output) > maxExecErrorOutput {
+		output = output[:maxExecErrorOutput]
+	}
+	result.Stderr = strings.TrimSpace(string(output))
+	return result
+}
+
+// contextUpgrader closes the SPDY connection of the exec session once the context is done
+type contextUpgrader struct {
+	spdy.Upgrader
+	ctx context.Context
+}
+
+func (self *contextUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := self.Upgrader.NewConnection(resp)
+	if err != nil || self.ctx.Done() == nil {
+		return conn, err
+	}
+	go func() {
+		select {
+		case <-self.ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
+		}
+	}()
+	return conn, nil
+}
+
+// newExecutor prepares the exec of cmd in the container, with stdin attached if the command reads from it.
+// Closing the connection when ctx is done ends the command in the container, the Stream of remotecommand
+// cannot be cancelled otherwise
+func newExecutor(ctx context.Context, podName string, containerName string, namespace string, token string, cmd []string, stdin bool, stderr bool) (remotecommand.Executor, error) {
+	client, err := getClient(token)
 	if err != nil {
 		return nil, err
 	}
+	config, clientset := client.config, client.clientset
 
 	// https://github.com/kubernetes/kubernetes/blob/release-1.22/test/e2e/framework/exec_util.go
 	// https://zhimin-wen.medium.com/programing-exec-into-a-pod-5f2a70bd93bb
@@ -94,8 +215,7 @@ func execCmd(podName string, containerName string, namespace string, token strin
 		Resource("pods").
 		Name(podName).
 		Namespace(namespace).
-		SubResource("exec").
-		Param("container", containerName)
+		SubResource("exec")
 
 	scheme := runtime.NewScheme()
 	if err := corev1.AddToScheme(scheme); err != nil {
@@ -104,19 +224,28 @@ func execCmd(podName string, containerName string, namespace string, token strin
 
 	parameterCodec := runtime.NewParameterCodec(scheme)
 	req.VersionedParams(&corev1.PodExecOptions{
-		Stdin:     false,
+		Stdin:     stdin,
 		Stdout:    true,
-		Stderr:    true,
+		Stderr:    stderr,
 		TTY:       false,
-		Container: podName,
+		Container: containerName,
 		Command:   cmd,
 	}, parameterCodec)
 
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewSPDYExecutorForTransports(transport, &contextUpgrader{upgrader, ctx}, "POST", req.URL())
+}
+
+func execCmd(podName string, containerName string, namespace string, token string, cmd []string) ([]byte, error) {
+	exec, err := newExecutor(context.Background(), podName, containerName, namespace, token, cmd, false, true)
 	if err != nil {
 		return nil, err
 	}
 
+	start := time.Now()
 	var stdout, stderr bytes.Buffer
 	err = exec.Stream(remotecommand.StreamOptions{
 		Stdin:             nil,
@@ -124,82 +253,58 @@ func execCmd(podName string, containerName string, namespace string, token strin
 		Stderr:            &stderr,
 		TerminalSizeQueue: &fixedTerminalSizeQueue{},
 	})
+	observeExec(cmd, start, err)
 	if err != nil {
-		return nil, err
+		return nil, newExecError(err, &stderr)
 	}
 
 	return stdout.Bytes(), nil
 }
 
-func execCmdToFile(podName string, containerName string, namespace string, token string, cmd []string) (string, error) {
-	config, err := loadConfig(token)
+// execCmdWithStdin runs cmd with stdin read from the reader until EOF, the command is ended when ctx is done
+func execCmdWithStdin(ctx context.Context, podName string, containerName string, namespace string, token string, cmd []string, stdin io.Reader) ([]byte, error) {
+	exec, err := newExecutor(ctx, podName, containerName, namespace, token, cmd, true, true)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	start := time.Now()
+	var stdout, stderr bytes.Buffer
+	err = exec.Stream(remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	observeExec(cmd, start, err)
 	if err != nil {
-		return "", err
-	}
-
-	// https://github.com/kubernetes/kubernetes/blob/release-1.22/test/e2e/framework/exec_util.go
-	// https://zhimin-wen.medium.com/programing-exec-into-a-pod-5f2a70bd93bb
-	req := clientset.CoreV1().
-		RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		Param("container", containerName)
-
-	scheme := runtime.NewScheme()
-	if err := corev1.AddToScheme(scheme); err != nil {
-		return "", err
+		return nil, newExecError(err, &stderr)
 	}
 
-	parameterCodec := runtime.NewParameterCodec(scheme)
-	req.VersionedParams(&corev1.PodExecOptions{
-		Stdin:     false,
-		Stdout:    true,
-		Stderr:    false,
-		TTY:       false,
-		Container: podName,
-		Command:   cmd,
-	}, parameterCodec)
+	return stdout.Bytes(), nil
+}
 
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+// execCmdToWriter runs cmd with stdout written to the writer as it comes, e.g. to pipe it into another exec.
+// The command is ended when ctx is done
+func execCmdToWriter(ctx context.Context, podName string, containerName string, namespace string, token string, cmd []string, stdout io.Writer) error {
+	exec, err := newExecutor(ctx, podName, containerName, namespace, token, cmd, false, true)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// create temp file
-	tempFile, err := ioutil.TempFile("", "k8s_cmd")
-	if err != nil {
-		return "", err
-	}
+	start := time.Now()
+	activeStreams.Inc()
+	defer activeStreams.Dec()
 
+	var stderr bytes.Buffer
 	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:             nil,
-		Stdout:            tempFile,
-		Stderr:            nil,
-		TerminalSizeQueue: &fixedTerminalSizeQueue{},
+		Stdout: stdout,
+		Stderr: &stderr,
 	})
+	observeExec(cmd, start, err)
 	if err != nil {
-		os.Remove(tempFile.Name())
-		return "", err
+		return newExecError(err, &stderr)
 	}
-
-	err = tempFile.Sync()
-	if err != nil {
-		os.Remove(tempFile.Name())
-		return "", err
-	}
-
-	defer tempFile.Close()
-
-	return tempFile.Name(), nil
+	return nil
 }
 
 type BufOrErr struct {
@@ -207,12 +312,17 @@ type BufOrErr struct {
 	err error
 }
 type StdoutChannel struct {
-	channel chan BufOrErr
-	closed  bool
+	channel   chan BufOrErr
+	closed    int32         // set atomically, Write is called by the exec session while Close by the reader
+	done      chan struct{} // closed by Close() to unblock pending writes
+	closeOnce sync.Once
 }
 
 func (self *StdoutChannel) Close() {
-	self.closed = true
+	self.closeOnce.Do(func() {
+		atomic.StoreInt32(&self.closed, 1)
+		close(self.done)
+	})
 }
 
 func (self *StdoutChannel) Channel() chan BufOrErr {
@@ -220,78 +330,80 @@ func (self *StdoutChannel) Channel() chan BufOrErr {
 }
 
 func (self *StdoutChannel) Write(data []byte) (n int, err error) {
-	if self.closed {
+	if atomic.LoadInt32(&self.closed) != 0 {
 		return 0, io.ErrClosedPipe
 	}
 	n = len(data)
 	var buf = make([]byte, n)
 	copy(buf, data)
-	self.channel <- BufOrErr{buf, nil}
-	return n, nil
-}
-
-func execCmdToChannel(podName string, containerName string, namespace string, token string, cmd []string) (*StdoutChannel, error) {
-	config, err := loadConfig(token)
-	if err != nil {
-		return nil, err
+	select {
+	case self.channel <- BufOrErr{buf, nil}:
+		return n, nil
+	case <-self.done:
+		// the reader has gone
+		return 0, io.ErrClosedPipe
 	}
+}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
+// Reader returns an io.Reader over the stdout, the error of the exec session (if any) is returned when it ends
+func (self *StdoutChannel) Reader() io.Reader {
+	return &stdoutReader{stdout: self}
+}
 
-	// https://github.com/kubernetes/kubernetes/blob/release-1.22/test/e2e/framework/exec_util.go
-	// https://zhimin-wen.medium.com/programing-exec-into-a-pod-5f2a70bd93bb
-	req := clientset.CoreV1().
-		RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		Param("container", containerName)
+type stdoutReader struct {
+	stdout  *StdoutChannel
+	pending []byte
+	err     error
+}
 
-	scheme := runtime.NewScheme()
-	if err := corev1.AddToScheme(scheme); err != nil {
-		return nil, err
+func (self *stdoutReader) Read(p []byte) (int, error) {
+	for len(self.pending) == 0 {
+		if self.err != nil {
+			return 0, self.err
+		}
+		bufOrErr, ok := <-self.stdout.Channel()
+		if !ok || bufOrErr.buf == nil {
+			self.err = bufOrErr.err
+			if self.err == nil {
+				self.err = io.EOF
+			}
+		} else {
+			self.pending = bufOrErr.buf
+		}
 	}
+	n := copy(p, self.pending)
+	self.pending = self.pending[n:]
+	return n, nil
+}
 
-	parameterCodec := runtime.NewParameterCodec(scheme)
-	req.VersionedParams(&corev1.PodExecOptions{
-		Stdin:     false,
-		Stdout:    true,
-		Stderr:    false,
-		TTY:       false,
-		Container: podName,
-		Command:   cmd,
-	}, parameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+// execCmdToChannel streams the stdout of the command, which is ended when ctx is done
+func execCmdToChannel(ctx context.Context, podName string, containerName string, namespace string, token string, cmd []string) (*StdoutChannel, error) {
+	exec, err := newExecutor(ctx, podName, containerName, namespace, token, cmd, false, false)
 	if err != nil {
 		return nil, err
 	}
 
 	stdout := StdoutChannel{
 		channel: make(chan BufOrErr, 100),
-		closed:  false,
+		done:    make(chan struct{}),
 	}
 	go (func() {
 		defer close(stdout.channel)
 
+		start := time.Now()
+		activeStreams.Inc()
+		defer activeStreams.Dec()
+
 		err = exec.Stream(remotecommand.StreamOptions{
 			Stdin:             nil,
 			Stdout:            &stdout,
 			Stderr:            nil,
 			TerminalSizeQueue: &fixedTerminalSizeQueue{},
 		})
-		if !stdout.closed {
-			if err != nil {
-				stdout.channel <- BufOrErr{nil, err}
-			} else {
-				stdout.channel <- BufOrErr{nil, nil}
-			}
+		observeExec(cmd, start, err)
+		select {
+		case stdout.channel <- BufOrErr{nil, err}: // nil buf marks the end, with error if failed
+		case <-stdout.done:
 		}
 		fmt.Println("Ended.")
 	})()
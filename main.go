@@ -1,46 +1,61 @@
 package main
 
 import (
-	"flag"
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func main() {
+var serverConfig *Config
 
-	var username, password, uiPath string
-	port := *flag.Int("port", 8080, "HTTP port to listen")
+func main() {
 
-	flag.StringVar(&username, "user", "", "Username to enable basic-authentication")
-	flag.StringVar(&password, "password", "", "Password to enable basic-authentication")
-	flag.StringVar(&uiPath, "ui-path", "./www/", "Path of static web sites")
-	flag.Parse()
+	cfg, err := LoadServerConfig(os.Args)
+	if err != nil {
+		exitOnError("Unable to load configuration", err)
+	}
+	serverConfig = cfg
 
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
+	router.Use(metricsMiddleware)
 
-	var r *gin.RouterGroup
-	if len(username) > 0 && len(password) > 0 {
-		r = router.Group("/", gin.BasicAuth(gin.Accounts{
-			username: password,
+	// probes and metrics are not protected by basic-authentication, probes need no client certificate either
+	router.GET("/healthz", getHealthz)
+	router.GET("/readyz", getReadyz)
+	var protected []gin.HandlerFunc
+	if len(cfg.TLSClientCA) > 0 {
+		protected = append(protected, requireClientCert)
+	}
+	router.GET("/metrics", append(protected, metricsHandler)...)
+
+	if len(cfg.Username) > 0 && len(cfg.Password) > 0 {
+		protected = append(protected, gin.BasicAuth(gin.Accounts{
+			cfg.Username: cfg.Password,
 		}))
-	} else {
-		r = router.Group("/")
 	}
+	r := router.Group("/", protected...)
 
 	// allow CORS request from localhost
 	r.Use(cors.New(cors.Config{
 		AllowMethods:     []string{"PUT", "PATCH", "GET", "POST", "DELETE"},
-		AllowHeaders:     []string{"Origin"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type"},
+		ExposeHeaders:    []string{"Content-Length", "X-Continue-Token", "X-File-Mtime", "X-File-Size", "X-Truncated", "X-Encoding", "X-Views", "X-Compression"},
 		AllowCredentials: true,
 		AllowOriginFunc: func(origin string) bool {
 			if u, err := url.Parse(origin); err == nil {
@@ -52,21 +67,73 @@ func main() {
 		MaxAge: time.Minute,
 	}))
 
+	features := cfg.Capabilities()
+
 	r.GET("/env", getEnv)
+	r.GET("/api/capabilities", getCapabilities)
 	r.GET("/api/pods", getPods)
-	r.GET("/api/pod/:pod/:container/file/list", getFiles)
-	r.GET("/api/pod/:pod/:container/file/view", viewFile)
-	r.GET("/api/pod/:pod/:container/file/download", downloadFile)
-	r.GET("/api/pod/:pod/:container/process/list", getProcesses)
+	r.GET("/api/nodes", getNodes)
+	r.GET("/api/node/:node/pods", getNodePods)
+
+	r.GET("/api/pod/:pod/manifest", getPodManifest)
+	r.GET("/api/pod/:pod/manifest/diff", getPodManifestDiff)
+	r.GET("/api/pod/:pod/volumes", getVolumes)
+	r.GET("/api/events", getEvents)
+	r.GET("/api/pod/:pod/events", getPodEvents)
+
+	if cfg.SampleInterval > 0 {
+		interval := time.Duration(cfg.SampleInterval) * time.Second
+		metricsHistory = NewMetricsHistory(interval, time.Duration(cfg.SampleRetention)*time.Minute)
+		go metricsHistory.Run(serverContext, cfg.SampleNamespace, interval)
 
-	dirPath, err := filepath.Abs(uiPath)
+		r.GET("/api/pod/:pod/metrics", getPodMetricsHistory)
+		r.GET("/api/node/:node/metrics", getNodeMetricsHistory)
+	}
+	if features.FileList {
+		r.GET("/api/pod/:pod/:container/file/list", getFiles)
+		r.GET("/api/pod/:pod/:container/file/du", getDiskUsage)
+	}
+	if features.FileView {
+		r.GET("/api/pod/:pod/:container/file/view", viewFile)
+		r.GET("/api/pod/:pod/:container/file/stat", getFileStat)
+		r.GET("/api/pod/:pod/:container/file/archive", listArchive)
+		r.GET("/api/pod/:pod/:container/data/sqlite/tables", getSqliteTables)
+		r.GET("/api/pod/:pod/:container/data/sqlite/rows", getSqliteRows)
+		r.GET("/api/pod/:pod/:container/data/records", queryRecords)
+		r.GET("/api/compare", compareFiles)
+	}
+	if features.FileDownload {
+		r.GET("/api/pod/:pod/:container/file/download", downloadFile)
+	}
+	if features.FileWrite {
+		r.PUT("/api/pod/:pod/:container/file/content", putFileContent)
+		r.POST("/api/pod/:pod/:container/file/mkdir", makeDirectory)
+		r.POST("/api/pod/:pod/:container/file/move", moveFile)
+		r.POST("/api/pod/:pod/:container/file/copy", copyFile)
+		r.POST("/api/pod/:pod/:container/file/chmod", changeMode)
+		r.POST("/api/pod/:pod/:container/file/chown", changeOwner)
+		r.DELETE("/api/pod/:pod/:container/file", deleteFile)
+		r.POST("/api/transfer", transferFiles)
+	}
+	if features.ProcessList {
+		r.GET("/api/pod/:pod/:container/process/list", getProcesses)
+		r.GET("/api/pod/:pod/:container/process/:pid", getProcessDetail)
+	}
+	if features.Network {
+		r.GET("/api/pod/:pod/:container/network", getNetworkConnections)
+	}
+	if features.Resources {
+		r.GET("/api/pod/:pod/:container/resources", getCgroupResources)
+	}
+
+	dirPath, err := filepath.Abs(cfg.UIPath)
 	if err != nil {
-		panic(err)
+		exitOnError("Invalid UI path", err)
 	}
 
 	files, err := ioutil.ReadDir(dirPath)
 	if err != nil {
-		panic(err)
+		exitOnError("Unable to read UI path", err)
 	}
 
 	// static web sites
@@ -82,8 +149,56 @@ func main() {
 	}
 	r.StaticFile("/", filepath.Join(dirPath, "index.html")) // default page
 
-	fmt.Printf("HTTP listening on port %d\n", port)
-	panic(router.Run(fmt.Sprintf(":%d", port)))
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: router,
+	}
+	if cfg.TLSEnabled() {
+		server.TLSConfig, err = NewServerTLSConfig(cfg)
+		if err != nil {
+			exitOnError("Unable to load TLS certificate", err)
+		}
+	}
+
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			fmt.Printf("HTTPS listening on port %d\n", cfg.Port)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			fmt.Printf("HTTP listening on port %d\n", cfg.Port)
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			exitOnError("HTTP server failed", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	// fail readiness first, then wait for in-flight requests (downloads, exec streams) to complete
+	atomic.StoreInt32(&shuttingDown, 1)
+	fmt.Printf("Received %v, shutting down in %d seconds at most\n", sig, cfg.ShutdownTimeout)
+
+	server.RegisterOnShutdown(stopServerContext)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println("Unable to drain active connections :", err)
+		server.Close()
+	}
+	fmt.Println("Server stopped")
+}
+
+func exitOnError(message string, err error) {
+	fmt.Println(message, ":", err)
+	os.Exit(1)
+}
+
+func getCapabilities(c *gin.Context) {
+	c.JSON(http.StatusOK, serverConfig.Capabilities())
 }
 
 func getEnv(c *gin.Context) {
@@ -95,14 +210,215 @@ func getEnv(c *gin.Context) {
 func getPods(c *gin.Context) {
 	namespace := c.Query("namespace")
 	token := c.Query("token")
-	pods, err := GetPods(namespace, token)
+	query := PodQuery{
+		LabelSelector: c.Query("labelSelector"),
+		FieldSelector: c.Query("fieldSelector"),
+		Phase:         c.Query("phase"),
+		Node:          c.Query("node"),
+		Name:          c.Query("name"),
+		Owner:         c.Query("owner"),
+		Status:        c.Query("status"),
+		SortBy:        c.Query("sort"),
+		Descending:    c.Query("order") == "desc",
+		Continue:      c.Query("continue"),
+	}
+	if limit := c.Query("limit"); len(limit) > 0 {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit " + limit})
+			return
+		}
+		query.Limit = value
+	}
+	if err := query.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	pods, continueToken, err := QueryPods(namespace, token, query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	} else {
+		// the response remains an array, token of next page is returned in header
+		c.Header("X-Continue-Token", continueToken)
 		c.JSON(http.StatusOK, pods)
 	}
 }
 
+func getNodes(c *gin.Context) {
+	token := c.Query("token")
+	nodes, err := GetNodes(token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, nodes)
+	}
+}
+
+func getNodePods(c *gin.Context) {
+	nodeName := c.Param("node")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	pods, err := GetNodePods(nodeName, namespace, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, pods)
+	}
+}
+
+func getPodMetricsHistory(c *gin.Context) {
+	podName := c.Param("pod")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	window, err := time.ParseDuration(c.DefaultQuery("window", "1h"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	history, err := GetPodMetricsHistory(podName, namespace, token, window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+func getNodeMetricsHistory(c *gin.Context) {
+	nodeName := c.Param("node")
+	token := c.Query("token")
+	window, err := time.ParseDuration(c.DefaultQuery("window", "1h"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	history, err := GetNodeMetricsHistory(nodeName, token, window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+func getPodManifest(c *gin.Context) {
+	podName := c.Param("pod")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	format := c.DefaultQuery("format", "yaml")
+	if !manifestFormats[format] {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be yaml or json"})
+		return
+	}
+	manifest, err := GetPodManifest(podName, namespace, token, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else if format == "json" {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", manifest)
+	} else {
+		c.Data(http.StatusOK, "application/yaml; charset=utf-8", manifest)
+	}
+}
+
+func getPodManifestDiff(c *gin.Context) {
+	podName := c.Param("pod")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	against := c.DefaultQuery("against", "owner")
+	if !manifestTargets[against] {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "against must be owner or previous"})
+		return
+	}
+	diff, err := DiffPodManifest(podName, namespace, token, against)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, diff)
+	}
+}
+
+func getVolumes(c *gin.Context) {
+	podName := c.Param("pod")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	volumes, err := GetVolumes(podName, namespace, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, volumes)
+	}
+}
+
+func getEvents(c *gin.Context) {
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	filter := EventFilter{
+		Kind: c.Query("kind"),
+		Name: c.Query("name"),
+		Type: c.Query("type"),
+	}
+
+	if c.Query("watch") == "true" {
+		events, err := WatchEvents(watchContext(c), namespace, token, []EventFilter{filter})
+		streamEvents(c, events, err)
+		return
+	}
+
+	events, err := GetEvents(namespace, token, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+func getPodEvents(c *gin.Context) {
+	podName := c.Param("pod")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	eventType := c.Query("type")
+
+	if c.Query("watch") == "true" {
+		events, err := WatchPodEvents(watchContext(c), podName, namespace, token, eventType)
+		streamEvents(c, events, err)
+		return
+	}
+
+	events, err := GetPodEvents(podName, namespace, token, eventType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+// watchContext ends when either the client disconnects or the server shuts down
+func watchContext(c *gin.Context) context.Context {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	go func() {
+		select {
+		case <-serverContext.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx
+}
+
+// streamEvents sends events as server-sent events until the channel is closed
+func streamEvents(c *gin.Context, events <-chan K8sEvent, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	c.Stream(func(w io.Writer) bool {
+		if event, ok := <-events; ok {
+			c.SSEvent("event", event)
+			return true
+		}
+		return false
+	})
+}
+
 func getFiles(c *gin.Context) {
 	podName := c.Param("pod")
 	containerName := c.Param("container")
@@ -117,6 +433,37 @@ func getFiles(c *gin.Context) {
 	}
 }
 
+// getDiskUsage streams progress of `du` as server-sent events, followed by the size tree as `result` event
+func getDiskUsage(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.DefaultQuery("path", "/")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "2"))
+	if err != nil || depth < 0 || depth > 10 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "depth must be between 0 and 10"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		return
+	}
+
+	// the client going away cancels the request context and ends `du`
+	tree, err := GetDiskUsage(c.Request.Context(), podName, containerName, path, namespace, token, depth, limit, func(progress DiskUsageProgress) {
+		c.SSEvent("progress", progress)
+		c.Writer.Flush()
+	})
+	if err != nil {
+		c.SSEvent("error", map[string]string{"error": err.Error()})
+	} else {
+		c.SSEvent("result", tree)
+	}
+	c.Writer.Flush()
+}
+
 func downloadFile(c *gin.Context) {
 	podName := c.Param("pod")
 	containerName := c.Param("container")
@@ -124,7 +471,8 @@ func downloadFile(c *gin.Context) {
 	namespace := c.Query("namespace")
 	token := c.Query("token")
 
-	stdout, err := DownloadSingleFile(podName, containerName, path, namespace, token)
+	// the client going away cancels the request context and ends `cat`
+	stdout, err := DownloadSingleFile(c.Request.Context(), podName, containerName, path, namespace, token)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
@@ -153,7 +501,8 @@ func downloadFile(c *gin.Context) {
 				if bufOrErr.buf == nil {
 					goto lbExit
 				}
-				_, err = w.Write(bufOrErr.buf)
+				n, err := w.Write(bufOrErr.buf)
+				downloadBytesTotal.Add(float64(n))
 				if err != nil {
 					goto lbExit
 				}
@@ -172,28 +521,489 @@ lbExit:
 
 }
 
+// bytes buffered between the exec stream and the response while viewing a file
+const previewBufferSize = 32 * 1024
+
+// viewFile streams the file with its detected content type, truncated to the max preview size. Text is converted to
+// UTF-8 from its detected encoding. `view=pretty` re-formats JSON and YAML, `view=hex` renders a hex dump
 func viewFile(c *gin.Context) {
 	podName := c.Param("pod")
 	containerName := c.Param("container")
 	path := c.DefaultQuery("path", "/")
 	namespace := c.Query("namespace")
 	token := c.Query("token")
+	view := c.DefaultQuery("view", "raw")
+	// content of containers is never sniffed nor run as a page of the inspector
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "sandbox")
+	if view != "raw" && view != "pretty" && view != "hex" {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "view must be raw, pretty or hex"})
+		return
+	}
 
-	tempFile, err := GetFileContent(podName, containerName, path, namespace, token)
+	// state of the file as read, to be sent back as precondition when saving it. It is left out if `stat`
+	// fails, e.g. it is not installed, reading the file is enough to view it
+	stat, err := GetFileStat(podName, containerName, path, namespace, token, "")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		fmt.Println("Unable to stat", path, err)
+		stat = nil
+	}
+	if stat != nil && stat.IsDir {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": path + " is a directory"})
 		return
 	}
-	defer os.Remove(tempFile)
 
+	maxSize := serverConfig.MaxPreviewSize
+	sizeKnown := stat != nil && stat.Size != nil
+	truncated := sizeKnown && *stat.Size > maxSize
 	_, filename := filepath.Split(path)
+	// name which tells the type of the content
+	typePath := path
+	var source io.Reader
+	// set when whether the content is truncated is known only once it is read
+	var readTruncated func() bool
+	if c.Query("decompress") == "true" {
+		preview, err := GetDecompressedPreview(c.Request.Context(), podName, containerName, path, namespace, token, maxSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		defer preview.Close()
+		if len(preview.Format) > 0 {
+			c.Header("X-Compression", preview.Format)
+			typePath = trimCompressionExtension(path)
+			filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+		}
+		if len(preview.Format) > 0 || !sizeKnown {
+			readTruncated = preview.Truncated
+			truncated = false
+		}
+		source = preview
+	} else if sizeKnown {
+		stdout, err := GetFilePreview(c.Request.Context(), podName, containerName, path, namespace, token, maxSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		defer stdout.Close()
+		source = stdout.Reader()
+	} else {
+		// one more byte tells whether the file is larger
+		stdout, err := GetFilePreview(c.Request.Context(), podName, containerName, path, namespace, token, maxSize+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		defer stdout.Close()
+		preview := newTruncatingReader(stdout.Reader(), maxSize)
+		readTruncated = preview.Truncated
+		source = preview
+	}
+
+	// the beginning of the file is enough to sniff the type, and tells whether reading it failed
+	reader := bufio.NewReaderSize(source, previewBufferSize)
+	head, err := reader.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	encoding := detectEncoding(head)
+	contentType := detectContentType(typePath, head, encoding)
+	if !isTextContent(contentType) {
+		// e.g. PDF starting with text
+		encoding = ""
+	}
+	views := fileViews(contentType)
 
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.File(tempFile)
+	if stat != nil {
+		c.Header("X-File-Mtime", strconv.FormatInt(stat.ModifyTime, 10))
+	}
+	if sizeKnown {
+		c.Header("X-File-Size", strconv.FormatInt(*stat.Size, 10))
+	}
+	if truncated {
+		// the client must not save a truncated file back
+		c.Header("X-Truncated", strconv.FormatInt(maxSize, 10))
+	} else if readTruncated != nil && view != "pretty" {
+		// sent after the content, once known
+		c.Header("Trailer", "X-Truncated")
+	}
+	// setTruncatedTrailer is called once the content is written
+	setTruncatedTrailer := func() {
+		if readTruncated != nil && readTruncated() {
+			c.Writer.Header().Set("X-Truncated", strconv.FormatInt(maxSize, 10))
+		}
+	}
+	if len(encoding) > 0 {
+		c.Header("X-Encoding", encoding)
+	}
+	c.Header("X-Views", strings.Join(views, ","))
+
+	switch view {
+	case "raw":
+		servedType := servedContentType(contentType, encoding)
+		disposition := "attachment"
+		if isInline(servedType) {
+			disposition = "inline"
+		}
+		c.Header("Content-Disposition", disposition+"; filename="+filename)
+		c.Header("Content-Type", servedType)
+		c.Status(http.StatusOK)
+		if _, err := io.Copy(c.Writer, decodeText(reader, encoding)); err != nil {
+			fmt.Println("Unable to view file", path, err)
+		}
+		setTruncatedTrailer()
+
+	case "pretty":
+		viewType := prettyViewType(contentType)
+		if len(viewType) == 0 || truncated {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "Only JSON and YAML files not truncated can be pretty-printed"})
+			return
+		}
+		content, err := ioutil.ReadAll(decodeText(reader, encoding))
+		if err == nil && readTruncated != nil && readTruncated() {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": "Only JSON and YAML files not truncated can be pretty-printed"})
+			return
+		}
+		if err == nil {
+			content, err = prettyPrint(content, viewType)
+		}
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "inline; filename="+filename)
+		c.Data(http.StatusOK, contentType, content)
+
+	case "hex":
+		c.Header("Content-Disposition", "inline; filename="+filename+".hex")
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := writeHexDump(c.Writer, reader); err != nil {
+			fmt.Println("Unable to view file", path, err)
+		}
+		setTruncatedTrailer()
+	}
+}
+
+// largest content accepted by putFileContent, which is held in memory
+const maxFileWriteSize = 16 * 1024 * 1024
+
+// putFileContent replaces the file with the request body, if it is unchanged since the client read it (mtime, size
+// or sha256 given as query parameters), or creates it if create=true and it does not exist. Conflicts return 409
+func putFileContent(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	if len(path) == 0 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "path is required"})
+		return
+	}
+
+	precondition := WritePrecondition{
+		Hash:   c.Query("hash"),
+		Create: c.Query("create") == "true",
+	}
+	for name, value := range map[string]**int64{"mtime": &precondition.ModifyTime, "size": &precondition.Size} {
+		if param, ok := c.GetQuery(name); ok {
+			number, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid " + name})
+				return
+			}
+			*value = &number
+		}
+	}
+	if !precondition.Create && precondition.ModifyTime == nil && precondition.Size == nil && len(precondition.Hash) == 0 {
+		c.JSON(http.StatusPreconditionRequired, map[string]string{"error": "mtime, size or hash of the file as read is required, or create=true"})
+		return
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxFileWriteSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(content) > maxFileWriteSize {
+		c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Content is larger than %d bytes", maxFileWriteSize)})
+		return
+	}
+
+	stat, err := WriteFileContent(podName, containerName, path, namespace, token, precondition, content)
+	if conflict, ok := err.(*FileConflictError); ok {
+		c.JSON(http.StatusConflict, map[string]interface{}{"error": conflict.Message, "current": conflict.Current})
+	} else {
+		fileOperationResponse(c, stat, err)
+	}
+}
+
+// fileOperationResponse returns the file after the operation, or the structured error with the status matching its reason
+func fileOperationResponse(c *gin.Context, stat *FileStat, err error) {
+	if opErr, ok := err.(*FileOperationError); ok {
+		c.JSON(opErr.StatusCode(), opErr)
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, stat)
+	}
+}
+
+func makeDirectory(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	parents := c.Query("parents") == "true"
+	stat, err := MakeDirectory(podName, containerName, path, namespace, token, parents)
+	fileOperationResponse(c, stat, err)
+}
+
+func moveFile(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	target := c.Query("target")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	overwrite := c.Query("overwrite") == "true"
+	stat, err := MoveFile(podName, containerName, path, target, namespace, token, overwrite)
+	fileOperationResponse(c, stat, err)
+}
+
+func copyFile(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	target := c.Query("target")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	overwrite := c.Query("overwrite") == "true"
+	stat, err := CopyFile(podName, containerName, path, target, namespace, token, overwrite)
+	fileOperationResponse(c, stat, err)
+}
+
+func changeMode(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	mode := c.Query("mode")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	recursive := c.Query("recursive") == "true"
+	stat, err := ChangeMode(podName, containerName, path, namespace, token, mode, recursive)
+	fileOperationResponse(c, stat, err)
+}
+
+func changeOwner(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	owner := c.Query("owner")
+	group := c.Query("group")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	recursive := c.Query("recursive") == "true"
+	stat, err := ChangeOwner(podName, containerName, path, namespace, token, owner, group, recursive)
+	fileOperationResponse(c, stat, err)
+}
+
+func deleteFile(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	recursive := c.Query("recursive") == "true"
+	confirm := c.Query("confirm")
+	err := DeleteFile(podName, containerName, path, namespace, token, recursive, confirm)
+	if opErr, ok := err.(*FileOperationError); ok {
+		c.JSON(opErr.StatusCode(), opErr)
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// transferFiles copies a file or directory into a directory of another pod or container,
+// which defaults to the same container and the parent directory of the source
+func transferFiles(c *gin.Context) {
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	source := FileTarget{
+		Pod:       c.Query("sourcePod"),
+		Container: c.Query("sourceContainer"),
+		Path:      c.Query("sourcePath"),
+	}
+	if len(source.Pod) == 0 || len(source.Container) == 0 || len(source.Path) == 0 || len(c.Query("targetPod")) == 0 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "sourcePod, sourceContainer, sourcePath and targetPod are required"})
+		return
+	}
+	sourceDir, _ := splitPath(source.Path)
+	target := FileTarget{
+		Pod:       c.Query("targetPod"),
+		Container: c.DefaultQuery("targetContainer", source.Container),
+		Path:      c.DefaultQuery("targetPath", sourceDir),
+	}
+
+	result, err := TransferFiles(watchContext(c), source, target, namespace, token)
+	if opErr, ok := err.(*FileOperationError); ok {
+		c.JSON(opErr.StatusCode(), opErr)
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func listArchive(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10000"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		return
+	}
+	listing, err := ListArchive(c.Request.Context(), podName, containerName, path, namespace, token, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, listing)
+	}
+}
+
+// getPage parses `offset` and `limit` query parameters, limit is between 1 and 1000
+func getPage(c *gin.Context) (int, int, error) {
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("Invalid offset")
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		return 0, 0, fmt.Errorf("limit must be between 1 and 1000")
+	}
+	return offset, limit, nil
+}
 
+func getSqliteTables(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	tables, err := GetSqliteTables(podName, containerName, path, namespace, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, tables)
+	}
+}
+
+func getSqliteRows(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	table := c.Query("table")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	offset, limit, err := getPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	rows, err := GetSqliteRows(podName, containerName, path, namespace, token, table, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, rows)
+	}
+}
+
+func queryRecords(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.Query("path")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	query := RecordQuery{Format: c.Query("format")}
+	var err error
+	if query.Offset, query.Limit, err = getPage(c); err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if columns := c.Query("columns"); len(columns) > 0 {
+		query.Columns = strings.Split(columns, ",")
+	}
+	for _, param := range c.QueryArray("filter") {
+		filter, err := ParseRecordFilter(param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		query.Filters = append(query.Filters, filter)
+	}
+
+	records, err := QueryRecords(c.Request.Context(), podName, containerName, path, namespace, token, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, records)
+	}
+}
+
+func getFileStat(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	path := c.DefaultQuery("path", "/")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	hashType := c.Query("hash")
+	if _, ok := hashCommands[hashType]; !ok && len(hashType) > 0 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "hash must be sha256 or md5"})
+		return
+	}
+	stat, err := GetFileStat(podName, containerName, path, namespace, token, hashType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, stat)
+	}
+}
+
+func compareFiles(c *gin.Context) {
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	left := FileTarget{
+		Pod:       c.Query("leftPod"),
+		Container: c.Query("leftContainer"),
+		Path:      c.Query("leftPath"),
+	}
+	right := FileTarget{
+		Pod:       c.Query("rightPod"),
+		Container: c.DefaultQuery("rightContainer", left.Container),
+		Path:      c.DefaultQuery("rightPath", left.Path),
+	}
+	if len(left.Pod) == 0 || len(left.Container) == 0 || len(left.Path) == 0 || len(right.Pod) == 0 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "leftPod, leftContainer, leftPath and rightPod are required"})
+		return
+	}
+	byTime := c.Query("mtime") == "true"
+	byHash := c.Query("hash") == "true"
+
+	result, err := CompareFiles(left, right, namespace, token, byTime, byHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, result)
+	}
 }
 
 func getProcesses(c *gin.Context) {
@@ -208,3 +1018,47 @@ func getProcesses(c *gin.Context) {
 		c.JSON(http.StatusOK, map[string]string{"result": string(result)})
 	}
 }
+
+func getNetworkConnections(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	result, err := GetNetworkConnections(podName, containerName, namespace, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func getCgroupResources(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	result, err := GetCgroupResources(podName, containerName, namespace, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func getProcessDetail(c *gin.Context) {
+	podName := c.Param("pod")
+	containerName := c.Param("container")
+	namespace := c.Query("namespace")
+	token := c.Query("token")
+	pid, err := strconv.Atoi(c.Param("pid"))
+	if err != nil || pid <= 0 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid pid " + c.Param("pid")})
+		return
+	}
+	detail, err := GetProcessDetail(podName, containerName, pid, namespace, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, detail)
+	}
+}
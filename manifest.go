@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+type ManifestDiff struct {
+	Source string `json:"source"` // the template compared with, e.g. ReplicaSet/web-5d4f8c (revision 3)
+	Target string `json:"target"` // e.g. Pod/web-5d4f8c-x7k2p
+	Diff   string `json:"diff"`   // unified diff of the specs in YAML, empty if identical
+}
+
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// values of `format` of GetPodManifest and `against` of DiffPodManifest, empty for the defaults
+var manifestFormats = map[string]bool{"": true, "yaml": true, "json": true}
+var manifestTargets = map[string]bool{"": true, "owner": true, "previous": true}
+
+// GetPodManifest returns the live pod object in YAML or JSON, without managed fields
+func GetPodManifest(podName string, namespace string, token string, format string) ([]byte, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := client.clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, countApiError("pods.get", err)
+	}
+	pod.ManagedFields = nil
+	// type meta is not filled by typed clients
+	pod.APIVersion = "v1"
+	pod.Kind = "Pod"
+
+	switch format {
+	case "json":
+		return json.MarshalIndent(pod, "", "  ")
+	case "yaml", "":
+		return yaml.Marshal(pod)
+	}
+	return nil, fmt.Errorf("Unsupported format %s", format)
+}
+
+// normalizeProbe drops values equal to the defaults of the API server
+func normalizeProbe(probe *corev1.Probe) {
+	if probe == nil {
+		return
+	}
+	for _, value := range []struct {
+		field        *int32
+		defaultValue int32
+	}{
+		{&probe.TimeoutSeconds, 1},
+		{&probe.PeriodSeconds, 10},
+		{&probe.SuccessThreshold, 1},
+		{&probe.FailureThreshold, 3},
+	} {
+		if *value.field == value.defaultValue {
+			*value.field = 0
+		}
+	}
+}
+
+// normalizePodSpec drops fields which are filled after pod creation (scheduling, admission) or defaulted
+// by the API server, so that they do not show up in the diff against templates
+func normalizePodSpec(spec *corev1.PodSpec) {
+	spec.NodeName = ""
+	// set by the ServiceAccount and Priority admission plugins
+	spec.DeprecatedServiceAccount = ""
+	if spec.ServiceAccountName == "default" {
+		spec.ServiceAccountName = ""
+	}
+	spec.Priority = nil
+	spec.PreemptionPolicy = nil
+	if spec.EnableServiceLinks != nil && *spec.EnableServiceLinks {
+		spec.EnableServiceLinks = nil
+	}
+
+	injected := make(map[string]bool)
+	volumes := spec.Volumes[:0]
+	for _, volume := range spec.Volumes {
+		if strings.HasPrefix(volume.Name, "kube-api-access-") {
+			injected[volume.Name] = true
+		} else {
+			volumes = append(volumes, volume)
+		}
+	}
+	spec.Volumes = volumes
+
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			mounts := containers[i].VolumeMounts[:0]
+			for _, mount := range containers[i].VolumeMounts {
+				if !injected[mount.Name] {
+					mounts = append(mounts, mount)
+				}
+			}
+			containers[i].VolumeMounts = mounts
+			normalizeProbe(containers[i].LivenessProbe)
+			normalizeProbe(containers[i].ReadinessProbe)
+			normalizeProbe(containers[i].StartupProbe)
+		}
+	}
+
+	tolerations := spec.Tolerations[:0]
+	for _, toleration := range spec.Tolerations {
+		// added by DefaultTolerationSeconds admission plugin
+		if toleration.TolerationSeconds != nil &&
+			(toleration.Key == corev1.TaintNodeNotReady || toleration.Key == corev1.TaintNodeUnreachable) {
+			continue
+		}
+		tolerations = append(tolerations, toleration)
+	}
+	spec.Tolerations = tolerations
+}
+
+func diffPodSpecs(source string, sourceSpec corev1.PodSpec, target string, targetSpec corev1.PodSpec) (*ManifestDiff, error) {
+	// specs are normalized in place, leave the objects they come from untouched
+	sourceSpec = *sourceSpec.DeepCopy()
+	targetSpec = *targetSpec.DeepCopy()
+	normalizePodSpec(&sourceSpec)
+	normalizePodSpec(&targetSpec)
+
+	a, err := yaml.Marshal(sourceSpec)
+	if err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(targetSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: source,
+		ToFile:   target,
+		Context:  3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ManifestDiff{Source: source, Target: target, Diff: diff}, nil
+}
+
+// ownerTemplate returns the pod template of the controller owning the pod
+func ownerTemplate(ctx context.Context, client *k8sClient, pod *corev1.Pod) (string, *corev1.PodTemplateSpec, metav1.Object, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "", nil, nil, fmt.Errorf("Pod %s is not managed by a controller", pod.Name)
+	}
+
+	name := ref.Kind + "/" + ref.Name
+	apps := client.clientset.AppsV1()
+	switch ref.Kind {
+	case "ReplicaSet":
+		owner, err := apps.ReplicaSets(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, nil, countApiError("replicasets.get", err)
+		}
+		return name, &owner.Spec.Template, owner, nil
+	case "StatefulSet":
+		owner, err := apps.StatefulSets(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, nil, countApiError("statefulsets.get", err)
+		}
+		return name, &owner.Spec.Template, owner, nil
+	case "DaemonSet":
+		owner, err := apps.DaemonSets(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, nil, countApiError("daemonsets.get", err)
+		}
+		return name, &owner.Spec.Template, owner, nil
+	case "Job":
+		owner, err := client.clientset.BatchV1().Jobs(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, nil, countApiError("jobs.get", err)
+		}
+		return name, &owner.Spec.Template, owner, nil
+	}
+	return "", nil, nil, fmt.Errorf("Unsupported owner %s", name)
+}
+
+// previousRevision returns the ReplicaSet of the Deployment whose revision precedes the given one
+func previousRevision(ctx context.Context, client *k8sClient, replicaSet metav1.Object) (string, *corev1.PodTemplateSpec, error) {
+	revision, err := strconv.ParseInt(replicaSet.GetAnnotations()[revisionAnnotation], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("ReplicaSet %s has no revision", replicaSet.GetName())
+	}
+	deployment := metav1.GetControllerOfNoCopy(replicaSet)
+	if deployment == nil || deployment.Kind != "Deployment" {
+		return "", nil, fmt.Errorf("ReplicaSet %s is not managed by a Deployment", replicaSet.GetName())
+	}
+
+	rsl, err := client.clientset.AppsV1().ReplicaSets(replicaSet.GetNamespace()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", nil, countApiError("replicasets.list", err)
+	}
+
+	var previous int64 = -1
+	var name string
+	var template *corev1.PodTemplateSpec
+	for i := range rsl.Items {
+		item := &rsl.Items[i]
+		owner := metav1.GetControllerOfNoCopy(item)
+		if owner == nil || owner.UID != deployment.UID {
+			continue
+		}
+		value, err := strconv.ParseInt(item.Annotations[revisionAnnotation], 10, 64)
+		if err == nil && value < revision && value > previous {
+			previous = value
+			name = fmt.Sprintf("ReplicaSet/%s (revision %d)", item.Name, value)
+			template = &item.Spec.Template
+		}
+	}
+	if template == nil {
+		return "", nil, fmt.Errorf("No revision before %d of Deployment %s", revision, deployment.Name)
+	}
+	return name, template, nil
+}
+
+// DiffPodManifest compares the pod spec with the template of its owner (against = "owner"),
+// or with the template of the previous Deployment revision (against = "previous")
+func DiffPodManifest(podName string, namespace string, token string, against string) (*ManifestDiff, error) {
+	ctx := context.TODO()
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := client.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, countApiError("pods.get", err)
+	}
+
+	source, template, owner, err := ownerTemplate(ctx, client, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	switch against {
+	case "owner", "":
+		if revision, ok := owner.GetAnnotations()[revisionAnnotation]; ok {
+			source = fmt.Sprintf("%s (revision %s)", source, revision)
+		}
+		return diffPodSpecs(source, template.Spec, "Pod/"+pod.Name, pod.Spec)
+
+	case "previous":
+		previous, previousTemplate, err := previousRevision(ctx, client, owner)
+		if err != nil {
+			return nil, err
+		}
+		return diffPodSpecs(previous, previousTemplate.Spec, "Pod/"+pod.Name, pod.Spec)
+	}
+	return nil, fmt.Errorf("Unable to compare against %s", against)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const metricsNamespace = "pod_inspector"
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, method and status code",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"route", "method", "status"})
+
+	execSessionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "exec_sessions_total",
+		Help:      "Number of exec sessions by command (ls, cat, ps...) and result (success, exit or error)",
+	}, []string{"command", "result"})
+
+	execSessionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "exec_session_duration_seconds",
+		Help:      "Duration of exec sessions by command",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 1800},
+	}, []string{"command"})
+
+	activeStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "active_streams",
+		Help:      "Number of exec sessions currently streaming to clients",
+	})
+
+	downloadBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "download_bytes_total",
+		Help:      "Bytes streamed to clients by file downloads",
+	})
+
+	k8sApiErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kubernetes_api_errors_total",
+		Help:      "Number of failed Kubernetes API calls by operation",
+	}, []string{"operation"})
+
+	clientCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "client_cache_requests_total",
+		Help:      "Lookups of the Kubernetes client cache by result (hit or miss)",
+	}, []string{"result"})
+)
+
+// metricsMiddleware records count and latency of every request
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if len(route) == 0 {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	httpRequestsTotal.WithLabelValues(route, c.Request.Method, status).Inc()
+	httpRequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+}
+
+var metricsHandler = gin.WrapH(promhttp.Handler())
+
+// observeExec records an exec session running `cmd` which started at `start`. A command exiting with non-zero code,
+// e.g. `test -e` of a missing file, is counted as `exit` rather than a failed API call
+func observeExec(cmd []string, start time.Time, err error) {
+	command := "unknown"
+	if len(cmd) > 0 {
+		command = cmd[0]
+	}
+	result := "success"
+	if _, ok := err.(utilexec.ExitError); ok {
+		result = "exit"
+	} else if err != nil {
+		result = "error"
+		k8sApiErrorsTotal.WithLabelValues("exec").Inc()
+	}
+	execSessionsTotal.WithLabelValues(command, result).Inc()
+	execSessionDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+}
+
+// countApiError records a failed Kubernetes API call and returns the error unchanged
+func countApiError(operation string, err error) error {
+	if err != nil {
+		k8sApiErrorsTotal.WithLabelValues(operation).Inc()
+	}
+	return err
+}
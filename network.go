@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SocketProcess struct {
+	Pid  int    `json:"pid"`
+	Name string `json:"name"`
+	Fd   int    `json:"fd"`
+}
+
+type Socket struct {
+	Protocol      string          `json:"protocol"` // tcp, tcp6, udp, udp6 or unix
+	State         string          `json:"state"`    // e.g. LISTEN, ESTABLISHED, TIME_WAIT
+	LocalAddress  string          `json:"localAddress,omitempty"`
+	LocalPort     int             `json:"localPort,omitempty"`
+	RemoteAddress string          `json:"remoteAddress,omitempty"`
+	RemotePort    int             `json:"remotePort,omitempty"`
+	Path          string          `json:"path,omitempty"` // unix sockets
+	Type          string          `json:"type,omitempty"` // stream, dgram or seqpacket for unix sockets
+	SendQueue     uint64          `json:"sendQueue"`
+	ReceiveQueue  uint64          `json:"receiveQueue"` // for listening TCP sockets, connections waiting to be accepted
+	Uid           int             `json:"uid"`
+	Inode         uint64          `json:"inode"`
+	Processes     []SocketProcess `json:"processes"` // processes having the socket open
+}
+
+type NetworkConnections struct {
+	Sockets []Socket `json:"sockets"`
+	// files which cannot be read, e.g. tcp6 if IPv6 is disabled, and `processes` if they cannot be listed
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// states of `st` column in /proc/net/tcp, see include/net/tcp_states.h
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+var unixSocketTypes = map[string]string{"0001": "stream", "0002": "dgram", "0005": "seqpacket"}
+
+// __SO_ACCEPTCON flag of unix sockets accepting connections
+const unixListeningFlag = 0x10000
+
+// parseHexAddress parses `0100007F:1F90` of /proc/net/tcp. Addresses are 32-bit words in host byte order
+// (little endian on amd64 and arm64), the port is big endian
+func parseHexAddress(value string) (string, int, error) {
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("Invalid address %s", value)
+	}
+	raw, err := hex.DecodeString(value[:i])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("Invalid address %s", value)
+	}
+	port, err := strconv.ParseUint(value[i+1:], 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid port %s", value)
+	}
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		binary.BigEndian.PutUint32(ip[word:], binary.LittleEndian.Uint32(raw[word:]))
+	}
+	return ip.String(), int(port), nil
+}
+
+// parseInetSockets parses /proc/net/tcp, tcp6, udp or udp6
+func parseInetSockets(protocol string, buffer []byte) []Socket {
+	sockets := make([]Socket, 0)
+	invalid := 0
+	var firstErr error
+	lines := strings.Split(string(buffer), "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		socket := Socket{Protocol: protocol, Processes: make([]SocketProcess, 0)}
+		var err error
+		if socket.LocalAddress, socket.LocalPort, err = parseHexAddress(fields[1]); err == nil {
+			socket.RemoteAddress, socket.RemotePort, err = parseHexAddress(fields[2])
+		}
+		if err != nil {
+			// logged once for the file
+			invalid++
+			firstErr = err
+			continue
+		}
+		socket.State = tcpStates[fields[3]]
+		if strings.HasPrefix(protocol, "udp") {
+			// UDP sockets are either connected or not, the latter receive from any address
+			socket.State = "UNCONN"
+			if fields[3] == "01" {
+				socket.State = "ESTABLISHED"
+			}
+		}
+		if queues := strings.Split(fields[4], ":"); len(queues) == 2 {
+			socket.SendQueue, _ = strconv.ParseUint(queues[0], 16, 64)
+			socket.ReceiveQueue, _ = strconv.ParseUint(queues[1], 16, 64)
+		}
+		socket.Uid, _ = strconv.Atoi(fields[7])
+		socket.Inode, _ = strconv.ParseUint(fields[9], 10, 64)
+		sockets = append(sockets, socket)
+	}
+	if invalid > 0 {
+		fmt.Println("Unable to parse", invalid, protocol, "sockets :", firstErr)
+	}
+	return sockets
+}
+
+// parseUnixSockets parses /proc/net/unix, whose path is empty for unnamed sockets and starts with @ for abstract ones
+func parseUnixSockets(buffer []byte) []Socket {
+	sockets := make([]Socket, 0)
+	lines := strings.Split(string(buffer), "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 7 {
+			continue
+		}
+		socket := Socket{Protocol: "unix", Type: unixSocketTypes[fields[4]], Processes: make([]SocketProcess, 0)}
+		flags, _ := strconv.ParseUint(fields[3], 16, 64)
+		switch {
+		case flags&unixListeningFlag != 0:
+			socket.State = "LISTEN"
+		case fields[5] == "03":
+			socket.State = "ESTABLISHED"
+		default:
+			socket.State = "UNCONN"
+		}
+		socket.Inode, _ = strconv.ParseUint(fields[6], 10, 64)
+		if len(fields) > 7 {
+			socket.Path = strings.Join(fields[7:], " ")
+		}
+		sockets = append(sockets, socket)
+	}
+	return sockets
+}
+
+// parseProcessNames parses `head -n 1 /proc/1/comm /proc/7/comm`, whose files are preceded by `==> /proc/1/comm <==`
+func parseProcessNames(buffer []byte, pids []int) map[int]string {
+	names := make(map[int]string)
+	pid := 0
+	if len(pids) == 1 {
+		// no header for a single file
+		pid = pids[0]
+	}
+	for _, line := range strings.Split(string(buffer), "\n") {
+		if strings.HasPrefix(line, "==> /proc/") && strings.HasSuffix(line, "/comm <==") {
+			pid, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "==> /proc/"), "/comm <=="))
+		} else if pid > 0 && len(line) > 0 {
+			names[pid] = line
+			pid = 0
+		}
+	}
+	return names
+}
+
+// socketProcesses maps inodes of sockets to the processes having them open, from `ls -l /proc/<pid>/fd` of all
+// processes. File descriptors of processes of other users are not readable unless the container runs as root
+func socketProcesses(podName string, containerName string, namespace string, token string) (map[uint64][]SocketProcess, error) {
+	buffer, err := execCmd(podName, containerName, namespace, token, []string{"ls", "/proc"})
+	if err != nil {
+		return nil, err
+	}
+	fdCmd := []string{"ls", "-l", "--color=never"}
+	commCmd := []string{"head", "-n", "1"}
+	var pids []int
+	for _, name := range strings.Fields(string(buffer)) {
+		if pid, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, pid)
+			fdCmd = append(fdCmd, "/proc/"+name+"/fd")
+			commCmd = append(commCmd, "/proc/"+name+"/comm")
+		}
+	}
+
+	// both fail on processes exited meanwhile or not readable, but still list the others
+	var fds, comms bytes.Buffer
+	parallel(func() error {
+		return execCmdToWriter(context.Background(), podName, containerName, namespace, token, fdCmd, &fds)
+	}, func() error {
+		return execCmdToWriter(context.Background(), podName, containerName, namespace, token, commCmd, &comms)
+	})
+
+	names := parseProcessNames(comms.Bytes(), pids)
+	processes := make(map[uint64][]SocketProcess)
+	pid := 0
+	if len(pids) == 1 {
+		pid = pids[0]
+	}
+	for _, line := range strings.Split(fds.String(), "\n") {
+		// directories are preceded by `/proc/1/fd:`
+		if strings.HasPrefix(line, "/proc/") && strings.HasSuffix(line, "/fd:") {
+			pid, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "/proc/"), "/fd:"))
+			continue
+		}
+		if file, ok := parseFdLine(line); ok && file.Type == "socket" && pid > 0 {
+			processes[file.Inode] = append(processes[file.Inode], SocketProcess{Pid: pid, Name: names[pid], Fd: file.Fd})
+		}
+	}
+	return processes, nil
+}
+
+var socketStateOrder = map[string]int{"LISTEN": 0, "UNCONN": 1, "ESTABLISHED": 2}
+
+// GetNetworkConnections lists sockets in the network namespace of the pod from /proc/net, since `ss` and `netstat`
+// are rarely installed. Listening sockets come first, then by protocol and local port
+func GetNetworkConnections(podName string, containerName string, namespace string, token string) (*NetworkConnections, error) {
+	files := []string{"tcp", "tcp6", "udp", "udp6", "unix"}
+	outputs := make([][]byte, len(files))
+	errs := make([]error, len(files))
+	var processes map[uint64][]SocketProcess
+	var processesErr error
+	fns := []func() error{func() error {
+		processes, processesErr = socketProcesses(podName, containerName, namespace, token)
+		return nil
+	}}
+	for i := range files {
+		i := i
+		fns = append(fns, func() error {
+			outputs[i], errs[i] = execCmd(podName, containerName, namespace, token, []string{"cat", "/proc/net/" + files[i]})
+			return nil
+		})
+	}
+	parallel(fns...)
+
+	result := &NetworkConnections{Sockets: make([]Socket, 0)}
+	for i, file := range files {
+		if errs[i] != nil {
+			if result.Errors == nil {
+				result.Errors = make(map[string]string)
+			}
+			result.Errors[file] = errs[i].Error()
+			continue
+		}
+		if file == "unix" {
+			result.Sockets = append(result.Sockets, parseUnixSockets(outputs[i])...)
+		} else {
+			result.Sockets = append(result.Sockets, parseInetSockets(file, outputs[i])...)
+		}
+	}
+	if len(result.Errors) == len(files) {
+		return nil, fmt.Errorf("Unable to read /proc/net : %s", result.Errors["tcp"])
+	}
+	// sockets are still listed, without their processes
+	if processesErr != nil {
+		if result.Errors == nil {
+			result.Errors = make(map[string]string)
+		}
+		result.Errors["processes"] = processesErr.Error()
+	}
+
+	for i := range result.Sockets {
+		if found, ok := processes[result.Sockets[i].Inode]; ok && result.Sockets[i].Inode > 0 {
+			result.Sockets[i].Processes = found
+		}
+	}
+
+	sort.SliceStable(result.Sockets, func(i, j int) bool {
+		left, right := result.Sockets[i], result.Sockets[j]
+		leftOrder, ok := socketStateOrder[left.State]
+		if !ok {
+			leftOrder = len(socketStateOrder)
+		}
+		rightOrder, ok := socketStateOrder[right.State]
+		if !ok {
+			rightOrder = len(socketStateOrder)
+		}
+		if leftOrder != rightOrder {
+			return leftOrder < rightOrder
+		}
+		if left.Protocol != right.Protocol {
+			return left.Protocol < right.Protocol
+		}
+		return left.LocalPort < right.LocalPort
+	})
+	return result, nil
+}
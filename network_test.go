@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHexAddress(t *testing.T) {
+	tests := []struct {
+		value string
+		ip    string
+		port  int
+		ok    bool
+	}{
+		{"0100007F:1F90", "127.0.0.1", 8080, true},
+		{"00000000:0000", "0.0.0.0", 0, true},
+		{"00000000000000000000000001000000:0050", "::1", 80, true},
+		{"0000000000000000FFFF00000100007F:01BB", "127.0.0.1", 443, true},
+		{"0100007F", "", 0, false},
+		{"01007F:0050", "", 0, false},
+		{"0100007F:XYZ", "", 0, false},
+	}
+	for _, test := range tests {
+		ip, port, err := parseHexAddress(test.value)
+		if (err == nil) != test.ok || ip != test.ip || port != test.port {
+			t.Errorf("%s : got %s:%d, %v", test.value, ip, port, err)
+		}
+	}
+}
+
+func TestParseInetSockets(t *testing.T) {
+	tcp := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n" +
+		"   0: 0100007F:1F90 00000000:0000 0A 00000000:00000005 00:00000000 00000000   101        0 957 1 00000000c81a398a 100 0 0 10 0\n" +
+		"   1: 0500000A:1F90 0700000A:C350 01 0000000A:00000000 00:00000000 00000000   101        0 958 1 00000000c81a398a 20 4 30 10 -1\n" +
+		"   2: ZZ:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 959 1 00000000c81a398a 100 0 0 10 0\n" +
+		"\n"
+	sockets := parseInetSockets("tcp", []byte(tcp))
+	expected := []Socket{
+		{Protocol: "tcp", State: "LISTEN", LocalAddress: "127.0.0.1", LocalPort: 8080, RemoteAddress: "0.0.0.0",
+			ReceiveQueue: 5, Uid: 101, Inode: 957, Processes: []SocketProcess{}},
+		{Protocol: "tcp", State: "ESTABLISHED", LocalAddress: "10.0.0.5", LocalPort: 8080, RemoteAddress: "10.0.0.7", RemotePort: 50000,
+			SendQueue: 10, Uid: 101, Inode: 958, Processes: []SocketProcess{}},
+	}
+	if !reflect.DeepEqual(sockets, expected) {
+		t.Errorf("got %+v", sockets)
+	}
+
+	udp := "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n" +
+		"  100: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 960 2 0000000000000000 0\n"
+	if sockets := parseInetSockets("udp", []byte(udp)); len(sockets) != 1 || sockets[0].State != "UNCONN" || sockets[0].LocalPort != 68 {
+		t.Errorf("got %+v", sockets)
+	}
+}
+
+func TestParseUnixSockets(t *testing.T) {
+	unix := "Num       RefCount Protocol Flags    Type St Inode Path\n" +
+		"00000000272515fc: 00000003 00000000 00000000 0001 03   956\n" +
+		"000000001ee049f9: 00000002 00000000 00010000 0001 01 53009 /run/app dir/app.sock\n" +
+		"000000001ee049fa: 00000002 00000000 00000000 0002 01 53010 @abstract\n"
+	expected := []Socket{
+		{Protocol: "unix", State: "ESTABLISHED", Type: "stream", Inode: 956, Processes: []SocketProcess{}},
+		{Protocol: "unix", State: "LISTEN", Type: "stream", Inode: 53009, Path: "/run/app dir/app.sock", Processes: []SocketProcess{}},
+		{Protocol: "unix", State: "UNCONN", Type: "dgram", Inode: 53010, Path: "@abstract", Processes: []SocketProcess{}},
+	}
+	if sockets := parseUnixSockets([]byte(unix)); !reflect.DeepEqual(sockets, expected) {
+		t.Errorf("got %+v", sockets)
+	}
+}
+
+func TestParseProcessNames(t *testing.T) {
+	output := "==> /proc/1/comm <==\nnginx\n\n==> /proc/7/comm <==\nnginx: worker\n"
+	if names := parseProcessNames([]byte(output), []int{1, 7}); !reflect.DeepEqual(names, map[int]string{1: "nginx", 7: "nginx: worker"}) {
+		t.Errorf("got %v", names)
+	}
+	// `head` prints no header for a single file
+	if names := parseProcessNames([]byte("sh\n"), []int{1}); !reflect.DeepEqual(names, map[int]string{1: "sh"}) {
+		t.Errorf("got %v", names)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type K8sNodeCondition struct {
+	Type               corev1.NodeConditionType `json:"type"`
+	Status             corev1.ConditionStatus   `json:"status"`
+	Reason             string                   `json:"reason"`
+	Message            string                   `json:"message"`
+	LastTransitionTime int64                    `json:"lastTransitionTime"` // unix timestamp
+}
+
+type K8sTaint struct {
+	Key    string             `json:"key"`
+	Value  string             `json:"value"`
+	Effect corev1.TaintEffect `json:"effect"`
+}
+
+type K8sNode struct {
+	Name           string             `json:"name"`
+	Age            int64              `json:"age"`
+	Ready          bool               `json:"ready"`
+	Unschedulable  bool               `json:"unschedulable"`
+	Roles          []string           `json:"roles"`
+	InternalIp     string             `json:"internalIp"`
+	KubeletVersion string             `json:"kubeletVersion"`
+	OsImage        string             `json:"osImage"`
+	Architecture   string             `json:"architecture"`
+	CpuUsage       int64              `json:"cpuUsage"`       // 1 Core = 1000 milli
+	CpuCapacity    int64              `json:"cpuCapacity"`    // 1 Core = 1000 milli
+	CpuAllocatable int64              `json:"cpuAllocatable"` // 1 Core = 1000 milli
+	CpuPercentage  float32            `json:"cpuPercentage"`  // usage / allocatable
+	RamUsage       int64              `json:"ramUsage"`       // KB
+	RamCapacity    int64              `json:"ramCapacity"`    // KB
+	RamAllocatable int64              `json:"ramAllocatable"` // KB
+	RamPercentage  float32            `json:"ramPercentage"`  // usage / allocatable
+	PodCapacity    int64              `json:"podCapacity"`
+	Conditions     []K8sNodeCondition `json:"conditions"`
+	Taints         []K8sTaint         `json:"taints"`
+}
+
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+func GetNodes(token string) ([]K8sNode, error) {
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	nl, err := client.clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, countApiError("nodes.list", err)
+	}
+
+	nodes := make([]K8sNode, 0, len(nl.Items))
+	nodeIndex := make(map[string]int)
+	for _, item := range nl.Items {
+		node := K8sNode{
+			Name:           item.Name,
+			Age:            int64(time.Now().UTC().Sub(item.CreationTimestamp.UTC()).Seconds()),
+			Unschedulable:  item.Spec.Unschedulable,
+			Roles:          []string{},
+			KubeletVersion: item.Status.NodeInfo.KubeletVersion,
+			OsImage:        item.Status.NodeInfo.OSImage,
+			Architecture:   item.Status.NodeInfo.Architecture,
+			CpuCapacity:    item.Status.Capacity.Cpu().ScaledValue(resource.Milli),
+			CpuAllocatable: item.Status.Allocatable.Cpu().ScaledValue(resource.Milli),
+			RamCapacity:    item.Status.Capacity.Memory().ScaledValue(resource.Kilo),
+			RamAllocatable: item.Status.Allocatable.Memory().ScaledValue(resource.Kilo),
+			PodCapacity:    item.Status.Capacity.Pods().Value(),
+			CpuUsage:       -1,
+			RamUsage:       -1,
+			Conditions:     make([]K8sNodeCondition, 0, len(item.Status.Conditions)),
+			Taints:         make([]K8sTaint, 0, len(item.Spec.Taints)),
+		}
+
+		for label := range item.Labels {
+			if strings.HasPrefix(label, nodeRoleLabelPrefix) {
+				node.Roles = append(node.Roles, strings.TrimPrefix(label, nodeRoleLabelPrefix))
+			}
+		}
+		sort.Strings(node.Roles)
+
+		for _, address := range item.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				node.InternalIp = address.Address
+				break
+			}
+		}
+
+		for _, condition := range item.Status.Conditions {
+			node.Conditions = append(node.Conditions, K8sNodeCondition{
+				Type:               condition.Type,
+				Status:             condition.Status,
+				Reason:             condition.Reason,
+				Message:            condition.Message,
+				LastTransitionTime: condition.LastTransitionTime.Unix(),
+			})
+			if condition.Type == corev1.NodeReady {
+				node.Ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+
+		for _, taint := range item.Spec.Taints {
+			node.Taints = append(node.Taints, K8sTaint{
+				Key:    taint.Key,
+				Value:  taint.Value,
+				Effect: taint.Effect,
+			})
+		}
+
+		nodeIndex[item.Name] = len(nodes)
+		nodes = append(nodes, node)
+	}
+
+	// get metrics
+	nml, err := client.metrics.MetricsV1beta1().NodeMetricses().List(context.TODO(), metav1.ListOptions{})
+	if countApiError("nodemetrics.list", err) == nil {
+		// merge into result
+		for _, item := range nml.Items {
+			if i, ok := nodeIndex[item.Name]; ok {
+				node := &nodes[i]
+				if cpuUsage := item.Usage.Cpu(); cpuUsage != nil {
+					node.CpuUsage = cpuUsage.ScaledValue(resource.Milli)
+					if node.CpuAllocatable > 0 {
+						percentage := float64(node.CpuUsage) / float64(node.CpuAllocatable)
+						node.CpuPercentage = float32(math.Round(percentage*1000)) / float32(1000.0)
+					}
+				}
+				if ramUsage := item.Usage.Memory(); ramUsage != nil {
+					node.RamUsage = ramUsage.ScaledValue(resource.Kilo)
+					if node.RamAllocatable > 0 {
+						percentage := float64(node.RamUsage) / float64(node.RamAllocatable)
+						node.RamPercentage = float32(math.Round(percentage*1000)) / float32(1000.0)
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
+
+// GetNodePods lists pods scheduled on the node
+func GetNodePods(nodeName string, namespace string, token string) ([]K8sPod, error) {
+	pods, _, err := QueryPods(namespace, token, PodQuery{Node: nodeName})
+	return pods, err
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	_ "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	_ "k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/tools/remotecommand"
-	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
+	_ "k8s.io/metrics/pkg/client/clientset/versioned"
 	//
 	// Uncomment to load all auth plugins
 	// _ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -33,55 +37,389 @@ type K8sContainer struct {
 	RamUsage int64 `json:"ramUsage"` // KB
 	RamLimit int64 `json:"ramLimit"` // KB
 
+	CpuRequest           int64    `json:"cpuRequest"` // 1 Core = 1000 milli
+	CpuPercentage        float32  `json:"cpuPercentage"`
+	CpuRequestPercentage float32  `json:"cpuRequestPercentage"`
+	RamRequest           int64    `json:"ramRequest"` // KB
+	RamPercentage        float32  `json:"ramPercentage"`
+	RamRequestPercentage float32  `json:"ramRequestPercentage"`
+	StorageRequest       int64    `json:"storageRequest"`             // ephemeral storage in KB
+	StorageLimit         int64    `json:"storageLimit"`               // ephemeral storage in KB
+	MissingResources     []string `json:"missingResources,omitempty"` // e.g. requests.cpu, limits.memory
+
+	Image     string                   `json:"image"`
+	State     string                   `json:"state"`            // running / waiting / terminated
+	Reason    string                   `json:"reason,omitempty"` // e.g. CrashLoopBackOff, OOMKilled, Completed
+	Message   string                   `json:"message,omitempty"`
+	ExitCode  *int32                   `json:"exitCode,omitempty"`
+	StartedAt int64                    `json:"startedAt,omitempty"` // unix timestamp
+	LastState *K8sContainerTermination `json:"lastState,omitempty"` // previous termination, if restarted
+}
+
+type K8sContainerTermination struct {
+	Reason     string `json:"reason"`
+	Message    string `json:"message,omitempty"`
+	ExitCode   int32  `json:"exitCode"`
+	Signal     int32  `json:"signal,omitempty"`
+	StartedAt  int64  `json:"startedAt"`  // unix timestamp
+	FinishedAt int64  `json:"finishedAt"` // unix timestamp
+}
+
+type K8sOwner struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Controller bool   `json:"controller"`
+}
+
+type K8sPodCondition struct {
+	Type               corev1.PodConditionType `json:"type"`
+	Status             corev1.ConditionStatus  `json:"status"`
+	Reason             string                  `json:"reason,omitempty"`
+	Message            string                  `json:"message,omitempty"`
+	LastTransitionTime int64                   `json:"lastTransitionTime"` // unix timestamp
 }
 
 type K8sPod struct {
-	Name          string                  `json:"name"`
-	Status        corev1.PodPhase         `json:"status"`
-	Age           int64                   `json:"age"`
-	Ready         int64                   `json:"ready"`
-	HostIp        string                  `json:"hostIp"`
-	PodIp         string                  `json:"podIp"`
-	CpuUsage      int64                   `json:"cpuUsage"` // 1 Core = 1000 milli
-	CpuLimit      int64                   `json:"cpuLimit"` // 1 Core = 1000 milli
-	CpuPercentage float32                 `json:"cpuPercentage"`
-	RamUsage      int64                   `json:"ramUsage"` // KB
-	RamLimit      int64                   `json:"ramLimit"` // KB
-	RamPercentage float32                 `json:"ramPercentage"`
-	Containers    map[string]K8sContainer `json:"containers"`
-}
-
-func GetPods(namespace string, token string) ([]K8sPod, error) {
-	podMap := make(map[string]*K8sPod)
-
-	config, err := loadConfig(token)
+	Name                 string                  `json:"name"`
+	Namespace            string                  `json:"namespace"`
+	Status               corev1.PodPhase         `json:"status"`
+	Reason               string                  `json:"reason,omitempty"` // e.g. Evicted
+	Age                  int64                   `json:"age"`
+	Ready                int64                   `json:"ready"`
+	HostIp               string                  `json:"hostIp"`
+	PodIp                string                  `json:"podIp"`
+	NodeName             string                  `json:"nodeName"`
+	QosClass             corev1.PodQOSClass      `json:"qosClass"`
+	Labels               map[string]string       `json:"labels"`
+	Annotations          map[string]string       `json:"annotations"`
+	Owners               []K8sOwner              `json:"owners"`
+	Conditions           []K8sPodCondition       `json:"conditions"`
+	CpuUsage             int64                   `json:"cpuUsage"` // 1 Core = 1000 milli
+	CpuLimit             int64                   `json:"cpuLimit"` // 1 Core = 1000 milli
+	CpuPercentage        float32                 `json:"cpuPercentage"`
+	RamUsage             int64                   `json:"ramUsage"` // KB
+	RamLimit             int64                   `json:"ramLimit"` // KB
+	RamPercentage        float32                 `json:"ramPercentage"`
+	CpuRequest           int64                   `json:"cpuRequest"` // 1 Core = 1000 milli
+	CpuRequestPercentage float32                 `json:"cpuRequestPercentage"`
+	RamRequest           int64                   `json:"ramRequest"` // KB
+	RamRequestPercentage float32                 `json:"ramRequestPercentage"`
+	StorageRequest       int64                   `json:"storageRequest"` // ephemeral storage in KB
+	StorageLimit         int64                   `json:"storageLimit"`   // ephemeral storage in KB
+	Containers           map[string]K8sContainer `json:"containers"`
+	InitContainers       map[string]K8sContainer `json:"initContainers"`
+	EphemeralContainers  map[string]K8sContainer `json:"ephemeralContainers"`
+}
+
+// resources checked during capacity reviews, reported in K8sContainer.MissingResources when not set
+var reviewedResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+func setContainerResources(container *K8sContainer, resources corev1.ResourceRequirements) {
+	container.CpuLimit = resources.Limits.Cpu().ScaledValue(resource.Milli)
+	container.CpuRequest = resources.Requests.Cpu().ScaledValue(resource.Milli)
+	container.RamLimit = resources.Limits.Memory().ScaledValue(resource.Kilo)
+	container.RamRequest = resources.Requests.Memory().ScaledValue(resource.Kilo)
+	container.StorageLimit = resources.Limits.StorageEphemeral().ScaledValue(resource.Kilo)
+	container.StorageRequest = resources.Requests.StorageEphemeral().ScaledValue(resource.Kilo)
+
+	container.MissingResources = nil
+	for _, name := range reviewedResources {
+		if _, ok := resources.Requests[name]; !ok {
+			container.MissingResources = append(container.MissingResources, "requests."+string(name))
+		}
+	}
+	for _, name := range reviewedResources {
+		if _, ok := resources.Limits[name]; !ok {
+			container.MissingResources = append(container.MissingResources, "limits."+string(name))
+		}
+	}
+}
+
+// percentage returns usage / base rounded to 3 decimals, or 0 if either is unknown
+func percentage(usage int64, base int64) float32 {
+	if usage < 0 || base <= 0 {
+		return 0
+	}
+	value := float64(usage) / float64(base)
+	return float32(math.Round(value*1000)) / float32(1000.0)
+}
+
+// podPercentages divides the usage of containers having a limit (or request) by the sum of the limits (or requests),
+// usage of containers without one would otherwise be counted against the limits of the others
+func podPercentages(pod *K8sPod) (cpu float32, cpuRequest float32, ram float32, ramRequest float32) {
+	var cpuLimited, cpuRequested, ramLimited, ramRequested int64 = -1, -1, -1, -1
+	add := func(sum *int64, usage int64, base int64) {
+		if usage >= 0 && base > 0 {
+			if *sum < 0 {
+				*sum = 0
+			}
+			*sum += usage
+		}
+	}
+	for _, container := range pod.Containers {
+		add(&cpuLimited, container.CpuUsage, container.CpuLimit)
+		add(&cpuRequested, container.CpuUsage, container.CpuRequest)
+		add(&ramLimited, container.RamUsage, container.RamLimit)
+		add(&ramRequested, container.RamUsage, container.RamRequest)
+	}
+	return percentage(cpuLimited, pod.CpuLimit), percentage(cpuRequested, pod.CpuRequest),
+		percentage(ramLimited, pod.RamLimit), percentage(ramRequested, pod.RamRequest)
+}
+
+func newK8sContainer(cs corev1.ContainerStatus) K8sContainer {
+	container := K8sContainer{
+		Ready:        cs.Ready,
+		RestartCount: cs.RestartCount,
+		CpuUsage:     -1,
+		RamUsage:     -1,
+		Image:        cs.Image,
+	}
+
+	if state := cs.State.Running; state != nil {
+		container.State = "running"
+		container.StartedAt = state.StartedAt.Unix()
+	} else if state := cs.State.Waiting; state != nil {
+		container.State = "waiting"
+		container.Reason = state.Reason
+		container.Message = state.Message
+	} else if state := cs.State.Terminated; state != nil {
+		container.State = "terminated"
+		container.Reason = state.Reason
+		container.Message = state.Message
+		container.ExitCode = &state.ExitCode
+		container.StartedAt = state.StartedAt.Unix()
+	}
+
+	if state := cs.LastTerminationState.Terminated; state != nil {
+		container.LastState = &K8sContainerTermination{
+			Reason:     state.Reason,
+			Message:    state.Message,
+			ExitCode:   state.ExitCode,
+			Signal:     state.Signal,
+			StartedAt:  state.StartedAt.Unix(),
+			FinishedAt: state.FinishedAt.Unix(),
+		}
+	}
+	return container
+}
+
+// getOwners returns owner references of the pod. Pods of a Deployment are owned by a ReplicaSet,
+// whose name is the Deployment name followed by the pod-template-hash, so the Deployment is appended as well
+func getOwners(pod *corev1.Pod) []K8sOwner {
+	owners := make([]K8sOwner, 0, len(pod.OwnerReferences))
+	for _, ref := range pod.OwnerReferences {
+		owner := K8sOwner{
+			Kind: ref.Kind,
+			Name: ref.Name,
+		}
+		if ref.Controller != nil {
+			owner.Controller = *ref.Controller
+		}
+		owners = append(owners, owner)
+
+		if hash, ok := pod.Labels["pod-template-hash"]; ok && ref.Kind == "ReplicaSet" && strings.HasSuffix(ref.Name, "-"+hash) {
+			owners = append(owners, K8sOwner{
+				Kind: "Deployment",
+				Name: strings.TrimSuffix(ref.Name, "-"+hash),
+			})
+		}
+	}
+	return owners
+}
+
+// PodQuery filters, sorts and paginates pod listing. Selectors and pagination are handled by the API server,
+// the rest is applied on each page
+type PodQuery struct {
+	LabelSelector string
+	FieldSelector string
+	Phase         string // shortcut of field selector status.phase
+	Node          string // shortcut of field selector spec.nodeName
+	Name          string // substring of pod name
+	Owner         string // owner name, or kind/name
+	Status        string // phase, pod reason or container reason, e.g. CrashLoopBackOff
+	SortBy        string // name, age, cpu, ram or restarts
+	Descending    bool
+	Limit         int64
+	Continue      string
+}
+
+var podSorters = map[string]func(a *K8sPod, b *K8sPod) bool{
+	"name":     func(a *K8sPod, b *K8sPod) bool { return a.Name < b.Name },
+	"age":      func(a *K8sPod, b *K8sPod) bool { return a.Age < b.Age },
+	"cpu":      func(a *K8sPod, b *K8sPod) bool { return a.CpuUsage < b.CpuUsage },
+	"ram":      func(a *K8sPod, b *K8sPod) bool { return a.RamUsage < b.RamUsage },
+	"restarts": func(a *K8sPod, b *K8sPod) bool { return a.RestartCount() < b.RestartCount() },
+}
+
+// Validate checks the sort key and the field selector, which would otherwise fail the listing.
+// Sorting and filters other than selectors apply to each page, so they cannot be combined with pagination
+func (self *PodQuery) Validate() error {
+	if _, ok := podSorters[self.SortBy]; !ok && len(self.SortBy) > 0 {
+		return fmt.Errorf("Unable to sort pods by %s", self.SortBy)
+	}
+	if self.Limit > 0 || len(self.Continue) > 0 {
+		if len(self.SortBy) > 0 || self.Descending {
+			return fmt.Errorf("sort and order cannot be combined with limit or continue")
+		}
+		if len(self.Name) > 0 || len(self.Owner) > 0 || len(self.Status) > 0 {
+			return fmt.Errorf("name, owner and status cannot be combined with limit or continue, use selectors instead")
+		}
+	}
+	if len(self.FieldSelector) > 0 {
+		if _, err := fields.ParseSelector(self.FieldSelector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryPods returns matched pods and the token to continue with the next page, which is empty on the last page
+func QueryPods(namespace string, token string, query PodQuery) ([]K8sPod, string, error) {
+	sortBy := query.SortBy
+	if len(sortBy) == 0 {
+		sortBy = "name"
+	}
+	less, ok := podSorters[sortBy]
+	if !ok {
+		return nil, "", fmt.Errorf("Unable to sort pods by %s", sortBy)
+	}
+
+	selectors := make([]fields.Selector, 0, 3)
+	if len(query.FieldSelector) > 0 {
+		selector, err := fields.ParseSelector(query.FieldSelector)
+		if err != nil {
+			return nil, "", err
+		}
+		selectors = append(selectors, selector)
+	}
+	if len(query.Phase) > 0 {
+		selectors = append(selectors, fields.OneTermEqualSelector("status.phase", query.Phase))
+	}
+	if len(query.Node) > 0 {
+		selectors = append(selectors, fields.OneTermEqualSelector("spec.nodeName", query.Node))
+	}
+
+	listOptions := metav1.ListOptions{
+		LabelSelector: query.LabelSelector,
+		Limit:         query.Limit,
+		Continue:      query.Continue,
+	}
+	if len(selectors) > 0 {
+		listOptions.FieldSelector = fields.AndSelectors(selectors...).String()
+	}
+
+	pods, continueToken, err := listPods(namespace, token, listOptions)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	matched := pods[:0]
+	for i := range pods {
+		if query.matches(&pods[i]) {
+			matched = append(matched, pods[i])
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if query.Descending {
+			return less(&matched[j], &matched[i])
+		}
+		return less(&matched[i], &matched[j])
+	})
+	return matched, continueToken, nil
+}
+
+func (query *PodQuery) matches(pod *K8sPod) bool {
+	if len(query.Name) > 0 && !strings.Contains(strings.ToLower(pod.Name), strings.ToLower(query.Name)) {
+		return false
+	}
+
+	if len(query.Owner) > 0 {
+		found := false
+		for _, owner := range pod.Owners {
+			if owner.Name == query.Owner || strings.EqualFold(owner.Kind+"/"+owner.Name, query.Owner) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(query.Status) > 0 {
+		if strings.EqualFold(string(pod.Status), query.Status) || strings.EqualFold(pod.Reason, query.Status) {
+			return true
+		}
+		for _, containers := range []map[string]K8sContainer{pod.Containers, pod.InitContainers} {
+			for _, container := range containers {
+				if strings.EqualFold(container.Reason, query.Status) {
+					return true
+				}
+				if container.LastState != nil && strings.EqualFold(container.LastState.Reason, query.Status) {
+					return true
+				}
+			}
+		}
+		return false
 	}
+	return true
+}
 
-	// create clientset
-	clientset, err := kubernetes.NewForConfig(config)
+// RestartCount sums restarts of all containers in the pod
+func (pod *K8sPod) RestartCount() int32 {
+	var count int32
+	for _, container := range pod.Containers {
+		count += container.RestartCount
+	}
+	return count
+}
+
+func listPods(namespace string, token string, listOptions metav1.ListOptions) ([]K8sPod, string, error) {
+	podMap := make(map[string]*K8sPod) // namespace/name => pod
+
+	client, err := getClient(token)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// get pod list
-	pl, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	pl, err := client.clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
 	if err != nil {
-		return nil, err
+		return nil, "", countApiError("pods.list", err)
 	}
 	for _, item := range pl.Items {
 		metadata := item.GetObjectMeta()
 		if metadata != nil {
 			pod := K8sPod{
-				Name:       metadata.GetName(),
-				Status:     item.Status.Phase,
-				HostIp:     item.Status.HostIP,
-				PodIp:      item.Status.PodIP,
-				Containers: make(map[string]K8sContainer),
-				Ready:      0,
-				CpuUsage:   -1,
-				RamUsage:   -1,
+				Name:                metadata.GetName(),
+				Namespace:           metadata.GetNamespace(),
+				Status:              item.Status.Phase,
+				Reason:              item.Status.Reason,
+				HostIp:              item.Status.HostIP,
+				PodIp:               item.Status.PodIP,
+				NodeName:            item.Spec.NodeName,
+				QosClass:            item.Status.QOSClass,
+				Labels:              item.Labels,
+				Annotations:         item.Annotations,
+				Owners:              getOwners(&item),
+				Conditions:          make([]K8sPodCondition, 0, len(item.Status.Conditions)),
+				Containers:          make(map[string]K8sContainer),
+				InitContainers:      make(map[string]K8sContainer),
+				EphemeralContainers: make(map[string]K8sContainer),
+				Ready:               0,
+				CpuUsage:            -1,
+				RamUsage:            -1,
+			}
+
+			for _, condition := range item.Status.Conditions {
+				pod.Conditions = append(pod.Conditions, K8sPodCondition{
+					Type:               condition.Type,
+					Status:             condition.Status,
+					Reason:             condition.Reason,
+					Message:            condition.Message,
+					LastTransitionTime: condition.LastTransitionTime.Unix(),
+				})
 			}
 
 			if item.Status.StartTime != nil {
@@ -89,79 +427,79 @@ func GetPods(namespace string, token string) ([]K8sPod, error) {
 			}
 
 			for _, cs := range item.Status.ContainerStatuses {
-				pod.Containers[cs.Name] = K8sContainer{
-					Ready:        cs.Ready,
-					RestartCount: cs.RestartCount,
-					CpuUsage:     -1,
-					RamUsage:     -1,
-				}
+				pod.Containers[cs.Name] = newK8sContainer(cs)
 				if cs.Ready {
 					pod.Ready++
 				}
 			}
+			for _, cs := range item.Status.InitContainerStatuses {
+				pod.InitContainers[cs.Name] = newK8sContainer(cs)
+			}
+			for _, cs := range item.Status.EphemeralContainerStatuses {
+				pod.EphemeralContainers[cs.Name] = newK8sContainer(cs)
+			}
 
 			for _, c := range item.Spec.Containers {
-				if container, ok := pod.Containers[c.Name]; ok {
-					cpuLimit := c.Resources.Limits.Cpu()
-					if cpuLimit != nil {
-						container.CpuLimit = cpuLimit.ScaledValue(resource.Milli)
-						pod.CpuLimit += container.CpuLimit
-					}
-					ramLimit := c.Resources.Limits.Memory()
-					if ramLimit != nil {
-						container.RamLimit = ramLimit.ScaledValue(resource.Kilo)
-						pod.RamLimit += container.RamLimit
+				container, ok := pod.Containers[c.Name]
+				if !ok {
+					// not started yet
+					container = K8sContainer{
+						CpuUsage: -1,
+						RamUsage: -1,
+						Image:    c.Image,
+						State:    "waiting",
 					}
-					pod.Containers[c.Name] = container
 				}
+				setContainerResources(&container, c.Resources)
+				pod.CpuLimit += container.CpuLimit
+				pod.CpuRequest += container.CpuRequest
+				pod.RamLimit += container.RamLimit
+				pod.RamRequest += container.RamRequest
+				pod.StorageLimit += container.StorageLimit
+				pod.StorageRequest += container.StorageRequest
+				pod.Containers[c.Name] = container
 			}
-			podMap[metadata.GetName()] = &pod
+			for _, c := range item.Spec.InitContainers {
+				if container, ok := pod.InitContainers[c.Name]; ok {
+					setContainerResources(&container, c.Resources)
+					pod.InitContainers[c.Name] = container
+				}
+			}
+			podMap[metadata.GetNamespace()+"/"+metadata.GetName()] = &pod
 		}
 	}
 
-	// metrics clientset
-	mc, err := metrics.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-
 	// get metrics
-	pml, err := mc.MetricsV1beta1().PodMetricses(namespace).List(context.TODO(), metav1.ListOptions{})
-	if err == nil {
+	pml, err := client.metrics.MetricsV1beta1().PodMetricses(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: listOptions.LabelSelector,
+	})
+	if countApiError("podmetrics.list", err) == nil {
 		// merge into result
 		for _, item := range pml.Items {
 			metadata := item.GetObjectMeta()
 			if metadata != nil {
-				if pod, ok := podMap[metadata.GetName()]; ok {
+				if pod, ok := podMap[metadata.GetNamespace()+"/"+metadata.GetName()]; ok {
 
 					for _, c := range item.Containers {
 						if container, ok := pod.Containers[c.Name]; ok {
-							cpuUsage := c.Usage.Cpu()
-							if cpuUsage != nil {
+							if cpuUsage := c.Usage.Cpu(); cpuUsage != nil {
 								container.CpuUsage = cpuUsage.ScaledValue(resource.Milli)
 								if pod.CpuUsage < 0 {
 									pod.CpuUsage = 0
 								}
 								pod.CpuUsage += container.CpuUsage
-
-								if pod.CpuLimit > 0 {
-									percentage := float64(pod.CpuUsage) / (float64(pod.CpuLimit) * float64(1.0))
-									pod.CpuPercentage = float32(math.Round(percentage*1000)) / float32(1000.0)
-								}
 							}
-							ramUsage := c.Usage.Memory()
-							if ramUsage != nil {
+							if ramUsage := c.Usage.Memory(); ramUsage != nil {
 								container.RamUsage = ramUsage.ScaledValue(resource.Kilo)
 								if pod.RamUsage < 0 {
 									pod.RamUsage = 0
 								}
 								pod.RamUsage += container.RamUsage
-
-								if pod.RamLimit > 0 {
-									percentage := float64(pod.RamUsage) / (float64(pod.RamLimit) * float64(1.0))
-									pod.RamPercentage = float32(math.Round(percentage*1000)) / float32(1000.0)
-								}
 							}
+							container.CpuPercentage = percentage(container.CpuUsage, container.CpuLimit)
+							container.CpuRequestPercentage = percentage(container.CpuUsage, container.CpuRequest)
+							container.RamPercentage = percentage(container.RamUsage, container.RamLimit)
+							container.RamRequestPercentage = percentage(container.RamUsage, container.RamRequest)
 							pod.Containers[c.Name] = container
 						}
 					}
@@ -172,8 +510,9 @@ func GetPods(namespace string, token string) ([]K8sPod, error) {
 
 	pods := make([]K8sPod, 0, len(podMap))
 	for _, pod := range podMap {
+		pod.CpuPercentage, pod.CpuRequestPercentage, pod.RamPercentage, pod.RamRequestPercentage = podPercentages(pod)
 		pods = append(pods, *pod)
 	}
 
-	return pods, nil
+	return pods, pl.Continue, nil
 }
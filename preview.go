@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"gopkg.in/yaml.v3"
+)
+
+// bytes of the beginning of a file used to detect its type and encoding
+const sniffSize = 4096
+
+// types of text files by extension, which are not known by `mime` package
+var textTypes = map[string]string{
+	".yaml":       "application/yaml",
+	".yml":        "application/yaml",
+	".json":       "application/json",
+	".ndjson":     "application/x-ndjson",
+	".jsonl":      "application/x-ndjson",
+	".toml":       "application/toml",
+	".md":         "text/markdown",
+	".csv":        "text/csv",
+	".log":        "text/plain",
+	".txt":        "text/plain",
+	".conf":       "text/plain",
+	".cfg":        "text/plain",
+	".ini":        "text/plain",
+	".properties": "text/plain",
+	".env":        "text/plain",
+	".sh":         "text/x-shellscript",
+}
+
+// detectEncoding tells the encoding of text from its beginning: utf-8, utf-16le, utf-16be or iso-8859-1.
+// Returns empty for binary content
+func detectEncoding(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		// UTF-16 without BOM, mostly ASCII characters have a zero byte every other byte
+		var evenZeros, oddZeros int
+		for i, b := range head {
+			if b == 0 && i%2 == 0 {
+				evenZeros++
+			} else if b == 0 {
+				oddZeros++
+			}
+		}
+		half := len(head) / 2
+		switch {
+		case oddZeros > half*3/4 && evenZeros == 0:
+			return "utf-16le"
+		case evenZeros > half*3/4 && oddZeros == 0:
+			return "utf-16be"
+		}
+		return ""
+	}
+
+	// the last character may be cut off at the end of head, when the file is longer
+	valid := head
+	for i := 1; len(head) == sniffSize && i < utf8.UTFMax && !utf8.Valid(valid); i++ {
+		valid = head[:len(head)-i]
+	}
+	switch {
+	case !isText(head):
+		return ""
+	case utf8.Valid(valid):
+		return "utf-8"
+	}
+	return "iso-8859-1"
+}
+
+// isText is false if there are control characters other than white spaces
+func isText(head []byte) bool {
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1B {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeText converts text in the encoding to UTF-8, without byte order mark
+func decodeText(reader io.Reader, encoding string) io.Reader {
+	switch encoding {
+	case "utf-16le":
+		return transform.NewReader(reader, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder())
+	case "utf-16be":
+		return transform.NewReader(reader, unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder())
+	case "iso-8859-1":
+		return transform.NewReader(reader, charmap.ISO8859_1.NewDecoder())
+	case "utf-8":
+		// strips the BOM only, content is passed as is
+		return transform.NewReader(reader, unicode.BOMOverride(transform.Nop))
+	}
+	return reader
+}
+
+// detectContentType determines the MIME type from magic bytes, or from the extension when the magic bytes
+// only tell it is text or unknown. Text types are given charset=utf-8, as text is converted to UTF-8
+func detectContentType(path string, head []byte, encoding string) string {
+	contentType := http.DetectContentType(head)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "text/plain" || mediaType == "application/octet-stream" {
+		ext := strings.ToLower(filepath.Ext(path))
+		if byExtension, ok := textTypes[ext]; ok && len(encoding) > 0 {
+			mediaType = byExtension
+		} else if byExtension := mime.TypeByExtension(ext); len(byExtension) > 0 {
+			mediaType, _, _ = mime.ParseMediaType(byExtension)
+		} else if len(encoding) > 0 {
+			mediaType = "text/plain"
+		}
+	}
+	if len(encoding) > 0 && isTextType(mediaType) {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+func isTextType(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/yaml", "application/x-ndjson", "application/toml", "application/xml", "text/xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// isTextContent tells the content type is text, to be converted to UTF-8
+func isTextContent(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return isTextType(mediaType)
+}
+
+// isScriptable tells browsers may run scripts of the type, e.g. HTML, SVG or XML with XSLT
+func isScriptable(mediaType string) bool {
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml", "text/xsl":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// servedContentType is the type files are served as. Files of containers must not run scripts in the origin
+// of the inspector, where the token is, so scriptable types are served as plain text (or binary)
+func servedContentType(contentType string, encoding string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !isScriptable(mediaType) {
+		return contentType
+	}
+	if len(encoding) > 0 {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isInline tells browsers can display the type, other types are downloaded as attachment
+func isInline(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if isScriptable(mediaType) {
+		return false
+	}
+	return isTextType(mediaType) || strings.HasPrefix(mediaType, "image/") || mediaType == "application/pdf"
+}
+
+// prettyViewType returns json or yaml if the content can be pretty-printed
+func prettyViewType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		return "json"
+	case "application/yaml":
+		return "yaml"
+	}
+	return ""
+}
+
+// fileViews lists the renderings available for the content type, raw being the file itself
+func fileViews(contentType string) []string {
+	views := []string{"raw"}
+	if len(prettyViewType(contentType)) > 0 {
+		views = append(views, "pretty")
+	}
+	return append(views, "hex")
+}
+
+// prettyPrint indents JSON, or re-formats YAML documents keeping comments and key order
+func prettyPrint(content []byte, viewType string) ([]byte, error) {
+	var buffer bytes.Buffer
+	switch viewType {
+	case "json":
+		if err := json.Indent(&buffer, content, "", "  "); err != nil {
+			return nil, fmt.Errorf("Invalid JSON : %v", err)
+		}
+		buffer.WriteByte('\n')
+		return buffer.Bytes(), nil
+
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		for {
+			var document yaml.Node
+			if err := decoder.Decode(&document); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("Invalid YAML : %v", err)
+			}
+			if err := encoder.Encode(&document); err != nil {
+				return nil, err
+			}
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+	return nil, fmt.Errorf("Unable to pretty-print %s", viewType)
+}
+
+// writeHexDump writes the content in `hexdump -C` format
+func writeHexDump(w io.Writer, reader io.Reader) error {
+	dumper := hex.Dumper(w)
+	if _, err := io.Copy(dumper, reader); err != nil {
+		return err
+	}
+	return dumper.Close()
+}
@@ -1,7 +1,323 @@
 package main
 
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 func GetPsResult(podName string, containerName string, namespace string, token string) ([]byte, error) {
 
 	cmd := []string{"ps", "auxf"}
 	return execCmd(podName, containerName, namespace, token, cmd)
 }
+
+type ProcessLimit struct {
+	Name  string `json:"name"`
+	Soft  string `json:"soft"` // number or unlimited
+	Hard  string `json:"hard"`
+	Units string `json:"units,omitempty"`
+}
+
+type ProcessCgroup struct {
+	Hierarchy   string   `json:"hierarchy"` // 0 for cgroup v2
+	Controllers []string `json:"controllers"`
+	Path        string   `json:"path"`
+}
+
+type ProcessFile struct {
+	Fd     int    `json:"fd"`
+	Type   string `json:"type"` // file, socket, pipe, anon_inode or other
+	Target string `json:"target"`
+	Inode  uint64 `json:"inode,omitempty"` // of sockets and pipes
+}
+
+type ProcessThread struct {
+	Tid        int     `json:"tid"`
+	Name       string  `json:"name"`
+	State      string  `json:"state"`
+	UserTime   float64 `json:"userTime"`   // seconds
+	SystemTime float64 `json:"systemTime"` // seconds
+	Processor  int     `json:"processor"`  // CPU last run on
+}
+
+type ProcessDetail struct {
+	Pid         int               `json:"pid"`
+	CommandLine []string          `json:"commandLine"`
+	Executable  string            `json:"executable"`
+	WorkingDir  string            `json:"workingDir"`
+	Status      map[string]string `json:"status"` // fields of /proc/<pid>/status, e.g. VmRSS, Threads
+	Environment map[string]string `json:"environment"`
+	Limits      []ProcessLimit    `json:"limits"`
+	Cgroups     []ProcessCgroup   `json:"cgroups"`
+	Files       []ProcessFile     `json:"files"`
+	Threads     []ProcessThread   `json:"threads"`
+	// parts which cannot be read, e.g. environment and files of processes of other users
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// bytes read of each file of /proc/<pid>, environ and cmdline have no limit
+const maxProcFileSize = 1024 * 1024
+
+// times in /proc/<pid>/stat are in clock ticks, USER_HZ is 100 on all architectures Kubernetes runs on
+const clockTicks = 100
+
+// splitNul splits NUL separated strings of `environ` and `cmdline`
+func splitNul(content string) []string {
+	content = strings.TrimRight(content, "\x00")
+	if len(content) == 0 {
+		return make([]string, 0)
+	}
+	return strings.Split(content, "\x00")
+}
+
+func parseProcStatus(content string) map[string]string {
+	status := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, ":"); i > 0 {
+			status[line[:i]] = strings.Join(strings.Fields(line[i+1:]), " ")
+		}
+	}
+	return status
+}
+
+// parseProcLimits parses the table of /proc/<pid>/limits, whose columns are aligned with the header
+func parseProcLimits(content string) ([]ProcessLimit, error) {
+	limits := make([]ProcessLimit, 0)
+	lines := strings.Split(content, "\n")
+	header := lines[0]
+	soft, hard, units := strings.Index(header, "Soft Limit"), strings.Index(header, "Hard Limit"), strings.Index(header, "Units")
+	if soft < 0 || hard < soft || units < hard {
+		return limits, fmt.Errorf("Unable to parse limits header : %s", header)
+	}
+	column := func(line string, start int, end int) string {
+		if start >= len(line) {
+			return ""
+		}
+		if end > len(line) {
+			end = len(line)
+		}
+		return strings.TrimSpace(line[start:end])
+	}
+	for _, line := range lines[1:] {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		limits = append(limits, ProcessLimit{
+			Name:  column(line, 0, soft),
+			Soft:  column(line, soft, hard),
+			Hard:  column(line, hard, units),
+			Units: column(line, units, len(line)),
+		})
+	}
+	return limits, nil
+}
+
+func parseProcCgroups(content string) []ProcessCgroup {
+	cgroups := make([]ProcessCgroup, 0)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		cgroup := ProcessCgroup{Hierarchy: fields[0], Controllers: make([]string, 0), Path: fields[2]}
+		if len(fields[1]) > 0 {
+			cgroup.Controllers = strings.Split(fields[1], ",")
+		}
+		cgroups = append(cgroups, cgroup)
+	}
+	return cgroups
+}
+
+// parseFdLine parses a line of `ls -l /proc/<pid>/fd`, e.g. `lrwx------ 1 root root 64 Oct 19 10:00 3 -> socket:[12345]`.
+// The line is split on the last ` -> `, so paths containing it are not parsed; only sockets are looked up this way
+func parseFdLine(line string) (ProcessFile, bool) {
+	arrow := strings.LastIndex(line, " -> ")
+	if arrow < 0 {
+		return ProcessFile{}, false
+	}
+	fields := strings.Fields(line[:arrow])
+	if len(fields) == 0 {
+		return ProcessFile{}, false
+	}
+	fd, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return ProcessFile{}, false
+	}
+	return newProcessFile(fd, line[arrow+4:]), true
+}
+
+// newProcessFile tells the type of a file descriptor from its target
+func newProcessFile(fd int, target string) ProcessFile {
+	file := ProcessFile{Fd: fd, Type: "file", Target: target}
+	switch {
+	case strings.HasPrefix(file.Target, "socket:["), strings.HasPrefix(file.Target, "pipe:["):
+		file.Type = file.Target[:strings.Index(file.Target, ":")]
+		file.Inode, _ = strconv.ParseUint(strings.TrimSuffix(file.Target[len(file.Type)+2:], "]"), 10, 64)
+	case strings.HasPrefix(file.Target, "anon_inode:"):
+		file.Type = "anon_inode"
+	case !strings.HasPrefix(file.Target, "/"):
+		file.Type = "other"
+	}
+	return file
+}
+
+// parseProcLinks parses `find <dir>/exe <dir>/cwd <dir>/fd -exec readlink {} ; -exec echo {} ;`, each target is
+// followed by the path of the link. Links which cannot be read (or closed meanwhile) print nothing
+func parseProcLinks(buffer []byte, dir string, detail *ProcessDetail) map[string]bool {
+	found := make(map[string]bool)
+	lines := strings.Split(string(buffer), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		target, path := lines[i], lines[i+1]
+		switch {
+		case path == dir+"/exe":
+			detail.Executable = target
+			found["exe"] = true
+		case path == dir+"/cwd":
+			detail.WorkingDir = target
+			found["cwd"] = true
+		case strings.HasPrefix(path, dir+"/fd/"):
+			if fd, err := strconv.Atoi(path[len(dir)+4:]); err == nil {
+				detail.Files = append(detail.Files, newProcessFile(fd, target))
+				found["fd"] = true
+			}
+		}
+	}
+	sort.Slice(detail.Files, func(i, j int) bool { return detail.Files[i].Fd < detail.Files[j].Fd })
+	return found
+}
+
+// parseProcStat parses /proc/<pid>/task/<tid>/stat. The name is in parentheses and may contain spaces or parentheses
+func parseProcStat(line string) (ProcessThread, bool) {
+	open, close := strings.Index(line, "("), strings.LastIndex(line, ")")
+	if open < 0 || close < open {
+		return ProcessThread{}, false
+	}
+	tid, err := strconv.Atoi(strings.TrimSpace(line[:open]))
+	if err != nil {
+		return ProcessThread{}, false
+	}
+	// fields after the name start from the 3rd field `state`
+	fields := strings.Fields(line[close+1:])
+	if len(fields) < 37 {
+		return ProcessThread{}, false
+	}
+	thread := ProcessThread{Tid: tid, Name: line[open+1 : close], State: fields[0]}
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	thread.UserTime = float64(utime) / clockTicks
+	thread.SystemTime = float64(stime) / clockTicks
+	thread.Processor, _ = strconv.Atoi(fields[36])
+	return thread, true
+}
+
+// GetProcessDetail reads /proc/<pid> in the container by 3 commands run concurrently: `head` of the files,
+// `readlink` of the executable, working directory and file descriptors, and `cat` of the threads.
+// Parts which cannot be read are reported in `Errors`, the process does not exist if its status cannot be read
+func GetProcessDetail(podName string, containerName string, pid int, namespace string, token string) (*ProcessDetail, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	detail := &ProcessDetail{
+		Pid:         pid,
+		CommandLine: make([]string, 0),
+		Environment: make(map[string]string),
+		Limits:      make([]ProcessLimit, 0),
+		Cgroups:     make([]ProcessCgroup, 0),
+		Files:       make([]ProcessFile, 0),
+		Threads:     make([]ProcessThread, 0),
+	}
+
+	// environ is the last, a value looking like a header of `head` can only cut itself
+	fileNames := []string{"status", "cmdline", "limits", "cgroup", "environ"}
+	headCmd := []string{"head", "-c", strconv.Itoa(maxProcFileSize)}
+	for _, name := range fileNames {
+		headCmd = append(headCmd, dir+"/"+name)
+	}
+	// there is no shell to expand /proc/<pid>/fd/* or /proc/<pid>/task/*/stat
+	linkCmd := []string{"find", dir + "/exe", dir + "/cwd", dir + "/fd", "-maxdepth", "1", "-type", "l",
+		"-exec", "readlink", "{}", ";", "-exec", "echo", "{}", ";"}
+	taskCmd := []string{"find", dir + "/task", "-mindepth", "2", "-maxdepth", "2", "-name", "stat", "-exec", "cat", "{}", ";"}
+
+	// all fail when some files cannot be read, but still print the others
+	var head, links, tasks bytes.Buffer
+	var headErr, linkErr, taskErr error
+	parallel(func() error {
+		headErr = execCmdToWriter(context.Background(), podName, containerName, namespace, token, headCmd, &head)
+		return nil
+	}, func() error {
+		linkErr = execCmdToWriter(context.Background(), podName, containerName, namespace, token, linkCmd, &links)
+		return nil
+	}, func() error {
+		taskErr = execCmdToWriter(context.Background(), podName, containerName, namespace, token, taskCmd, &tasks)
+		return nil
+	})
+
+	addError := func(part string, err error) {
+		if detail.Errors == nil {
+			detail.Errors = make(map[string]string)
+		}
+		detail.Errors[part] = err.Error()
+	}
+
+	files := parseHeadFiles(head.Bytes())
+	status, ok := files[dir+"/status"]
+	if !ok {
+		if headErr == nil {
+			headErr = fmt.Errorf("No output")
+		}
+		return nil, fmt.Errorf("Unable to read process %d : %v", pid, headErr)
+	}
+	detail.Status = parseProcStatus(status)
+	for _, name := range fileNames[1:] {
+		content, ok := files[dir+"/"+name]
+		if !ok {
+			if headErr != nil {
+				addError(name, headErr)
+			}
+			continue
+		}
+		switch name {
+		case "cmdline":
+			detail.CommandLine = splitNul(content)
+		case "limits":
+			var err error
+			if detail.Limits, err = parseProcLimits(content); err != nil {
+				addError(name, err)
+			}
+		case "cgroup":
+			detail.Cgroups = parseProcCgroups(content)
+		case "environ":
+			for _, variable := range splitNul(content) {
+				if i := strings.Index(variable, "="); i > 0 {
+					detail.Environment[variable[:i]] = variable[i+1:]
+				}
+			}
+		}
+	}
+
+	found := parseProcLinks(links.Bytes(), dir, detail)
+	for _, name := range []string{"exe", "cwd", "fd"} {
+		if found[name] {
+			continue
+		}
+		if linkErr != nil {
+			addError(name, linkErr)
+		} else if name != "fd" {
+			// readlink fails quietly on links of processes of other users, a process may have no files open
+			addError(name, fmt.Errorf("Unable to read %s/%s", dir, name))
+		}
+	}
+
+	// find fails on threads exited meanwhile, but still lists the others
+	if taskErr != nil {
+		addError("task", taskErr)
+	}
+	for _, line := range strings.Split(tasks.String(), "\n") {
+		if thread, ok := parseProcStat(line); ok {
+			detail.Threads = append(detail.Threads, thread)
+		}
+	}
+	return detail, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProcLimits(t *testing.T) {
+	content := "Limit                     Soft Limit           Hard Limit           Units     \n" +
+		"Max cpu time              unlimited            unlimited            seconds   \n" +
+		"Max open files            1048576              1048576              files     \n" +
+		"Max nice priority         0                    0                    "
+	limits, err := parseProcLimits(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ProcessLimit{
+		{Name: "Max cpu time", Soft: "unlimited", Hard: "unlimited", Units: "seconds"},
+		{Name: "Max open files", Soft: "1048576", Hard: "1048576", Units: "files"},
+		{Name: "Max nice priority", Soft: "0", Hard: "0"},
+	}
+	if !reflect.DeepEqual(limits, expected) {
+		t.Errorf("got %+v", limits)
+	}
+
+	if _, err := parseProcLimits("cat: /proc/1/limits: Permission denied"); err == nil {
+		t.Error("expected an error for a missing header")
+	}
+}
+
+func TestParseFdLine(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		file ProcessFile
+	}{
+		{"lrwx------ 1 root root 64 Oct 19 10:00 3 -> socket:[12345]", true, ProcessFile{Fd: 3, Type: "socket", Target: "socket:[12345]", Inode: 12345}},
+		{"l-wx------ 1 root root 64 Oct 19 10:00 1 -> pipe:[59327]", true, ProcessFile{Fd: 1, Type: "pipe", Target: "pipe:[59327]", Inode: 59327}},
+		{"lrwx------ 1 root root 64 Oct 19 10:00 4 -> anon_inode:[eventpoll]", true, ProcessFile{Fd: 4, Type: "anon_inode", Target: "anon_inode:[eventpoll]"}},
+		{"lr-x------ 1 root root 64 Oct 19 10:00 0 -> /dev/null", true, ProcessFile{Fd: 0, Type: "file", Target: "/dev/null"}},
+		// only sockets are looked up by lines of `ls`, paths containing the separator are skipped
+		{"lr-x------ 1 root root 64 Oct 19 10:00 5 -> /tmp/a -> b", false, ProcessFile{}},
+		{"total 0", false, ProcessFile{}},
+		{"/proc/1/fd:", false, ProcessFile{}},
+	}
+	for _, test := range tests {
+		file, ok := parseFdLine(test.line)
+		if ok != test.ok || file != test.file {
+			t.Errorf("%s : got %+v, %v", test.line, file, ok)
+		}
+	}
+}
+
+func TestParseProcLinks(t *testing.T) {
+	output := "/usr/bin/app\n/proc/7/exe\n" +
+		"/srv\n/proc/7/cwd\n" +
+		"/var/log/a -> b.log\n/proc/7/fd/12\n" +
+		"socket:[42]\n/proc/7/fd/3\n" +
+		"/dev/null\n/proc/7/fd/0\n"
+	detail := &ProcessDetail{Files: make([]ProcessFile, 0)}
+	found := parseProcLinks([]byte(output), "/proc/7", detail)
+	if !found["exe"] || !found["cwd"] || !found["fd"] {
+		t.Errorf("got %v", found)
+	}
+	if detail.Executable != "/usr/bin/app" || detail.WorkingDir != "/srv" {
+		t.Errorf("got executable %s, working dir %s", detail.Executable, detail.WorkingDir)
+	}
+	expected := []ProcessFile{
+		{Fd: 0, Type: "file", Target: "/dev/null"},
+		{Fd: 3, Type: "socket", Target: "socket:[42]", Inode: 42},
+		{Fd: 12, Type: "file", Target: "/var/log/a -> b.log"},
+	}
+	if !reflect.DeepEqual(detail.Files, expected) {
+		t.Errorf("got %+v", detail.Files)
+	}
+
+	// links of processes of other users are not readable
+	detail = &ProcessDetail{Files: make([]ProcessFile, 0)}
+	if found := parseProcLinks([]byte("/dev/null\n/proc/7/fd/0\n"), "/proc/7", detail); found["exe"] || found["cwd"] {
+		t.Errorf("got %v", found)
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	line := "29386 (my (app) 1) S 29378 29386 29378 0 -1 4194304 115 0 0 0 250 30 0 0 20 0 1 0 581945 2703360 322 " +
+		"18446744073709551615 94485628473344 94485628493225 140726747940576 0 0 0 0 0 0 0 0 0 17 3 0 0 0 0 0"
+	thread, ok := parseProcStat(line)
+	expected := ProcessThread{Tid: 29386, Name: "my (app) 1", State: "S", UserTime: 2.5, SystemTime: 0.3, Processor: 3}
+	if !ok || thread != expected {
+		t.Errorf("got %+v, %v", thread, ok)
+	}
+	if _, ok := parseProcStat("29386 (app) S 1 2"); ok {
+		t.Error("expected a short line to be skipped")
+	}
+}
+
+func TestParseProcCgroupsAndStatus(t *testing.T) {
+	cgroups := parseProcCgroups("12:cpu,cpuacct:/kubepods/pod1\n0::/kubepods/pod1/app")
+	expected := []ProcessCgroup{
+		{Hierarchy: "12", Controllers: []string{"cpu", "cpuacct"}, Path: "/kubepods/pod1"},
+		{Hierarchy: "0", Controllers: []string{}, Path: "/kubepods/pod1/app"},
+	}
+	if !reflect.DeepEqual(cgroups, expected) {
+		t.Errorf("got %+v", cgroups)
+	}
+
+	status := parseProcStatus("Name:\tnginx\nVmRSS:\t   10240 kB\nUid:\t101\t101\t101\t101")
+	if status["Name"] != "nginx" || status["VmRSS"] != "10240 kB" || status["Uid"] != "101 101 101 101" {
+		t.Errorf("got %v", status)
+	}
+
+	if args := splitNul("nginx\x00-g\x00daemon off;\x00"); !reflect.DeepEqual(args, []string{"nginx", "-g", "daemon off;"}) {
+		t.Errorf("got %q", args)
+	}
+	if args := splitNul(""); len(args) != 0 {
+		t.Errorf("got %q", args)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RecordFilter matches records whose column is equal (=), not equal (!=) or contains case-insensitively (~) the value
+type RecordFilter struct {
+	Column   string
+	Operator string
+	Value    string
+}
+
+type RecordQuery struct {
+	Format  string   // csv, tsv or ndjson; detected from the extension if empty
+	Columns []string // columns to return, all if empty. Nested fields of JSON are separated by dots, e.g. req.status
+	Filters []RecordFilter
+	Offset  int // number of matching records to skip
+	Limit   int
+}
+
+type Records struct {
+	Format  string          `json:"format"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	Offset  int             `json:"offset"`
+	Scanned int64           `json:"scanned"`           // records read from the file
+	Invalid int64           `json:"invalid,omitempty"` // lines which are not JSON objects
+	More    bool            `json:"more"`              // more records match after the returned ones
+}
+
+var recordFormats = map[string]string{
+	".csv":    "csv",
+	".tsv":    "tsv",
+	".ndjson": "ndjson",
+	".jsonl":  "ndjson",
+	".json":   "ndjson",
+}
+
+// ParseRecordFilter parses a filter such as `status=500`, `level!=debug` or `msg~timeout`
+func ParseRecordFilter(filter string) (RecordFilter, error) {
+	for _, operator := range []string{"!=", "~", "="} {
+		if i := strings.Index(filter, operator); i > 0 {
+			return RecordFilter{Column: filter[:i], Operator: operator, Value: filter[i+len(operator):]}, nil
+		}
+	}
+	return RecordFilter{}, fmt.Errorf("Invalid filter %s, expecting column=value, column!=value or column~value", filter)
+}
+
+// recordValue looks up the column in a record, which is a map of strings for CSV, or a JSON object
+func recordValue(record map[string]interface{}, column string) interface{} {
+	if value, ok := record[column]; ok {
+		return value
+	}
+	var value interface{} = record
+	for _, key := range strings.Split(column, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func valueString(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	buffer, _ := json.Marshal(value)
+	return string(buffer)
+}
+
+func (self *RecordFilter) matches(record map[string]interface{}) bool {
+	value := valueString(recordValue(record, self.Column))
+	switch self.Operator {
+	case "=":
+		return value == self.Value
+	case "!=":
+		return value != self.Value
+	case "~":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(self.Value))
+	}
+	return false
+}
+
+// recordReader returns records one after another, io.EOF at the end
+type recordReader func() (map[string]interface{}, error)
+
+func newCsvReader(reader io.Reader, comma rune) (recordReader, []string, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = comma
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("Empty file")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return func() (map[string]interface{}, error) {
+		fields, err := csvReader.Read()
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			if i < len(fields) {
+				record[column] = fields[i]
+			}
+		}
+		return record, nil
+	}, header, nil
+}
+
+func newNdjsonReader(reader io.Reader, invalid *int64) recordReader {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return func() (map[string]interface{}, error) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			// numbers are kept as written, large ids would lose precision as float64
+			decoder.UseNumber()
+			var record map[string]interface{}
+			if err := decoder.Decode(&record); err != nil || record == nil {
+				*invalid++
+				continue
+			}
+			return record, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
+
+// QueryRecords streams a CSV, TSV or NDJSON file (which may be compressed) from the container, and returns the
+// records matching all filters, projected to the columns. Once a record after the page is found, `cat` is ended
+// rather than streaming the rest of the file, and so it is when ctx is done
+func QueryRecords(ctx context.Context, podName string, containerName string, path string, namespace string, token string, query RecordQuery) (*Records, error) {
+	format := query.Format
+	if len(format) == 0 {
+		format = recordFormats[strings.ToLower(filepath.Ext(trimCompressionExtension(path)))]
+	}
+	if format != "csv" && format != "tsv" && format != "ndjson" {
+		return nil, fmt.Errorf("Unknown format of %s, expecting csv, tsv or ndjson", path)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stdout, err := DownloadSingleFile(ctx, podName, containerName, path, namespace, token)
+	if err != nil {
+		return nil, err
+	}
+	defer stdout.Close()
+
+	decompressor, _, err := decompressReader(stdout.Reader())
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	result := &Records{Format: format, Offset: query.Offset, Rows: make([][]interface{}, 0)}
+	var next recordReader
+	var header []string
+	switch format {
+	case "csv", "tsv":
+		comma := ','
+		if format == "tsv" {
+			comma = '\t'
+		}
+		if next, header, err = newCsvReader(decompressor, comma); err != nil {
+			return nil, err
+		}
+	case "ndjson":
+		next = newNdjsonReader(decompressor, &result.Invalid)
+	}
+
+	columns := query.Columns
+	if len(columns) == 0 {
+		columns = header
+	} else if header != nil {
+		known := make(map[string]bool)
+		for _, column := range header {
+			known[column] = true
+		}
+		for _, column := range columns {
+			if !known[column] {
+				return nil, fmt.Errorf("Unknown column %s", column)
+			}
+		}
+	}
+
+	var matched int
+	var records []map[string]interface{}
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		result.Scanned++
+
+		ok := true
+		for i := range query.Filters {
+			if !query.Filters[i].matches(record) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		matched++
+		if matched <= query.Offset {
+			continue
+		}
+		if len(records) >= query.Limit {
+			result.More = true
+			cancel()
+			break
+		}
+		records = append(records, record)
+	}
+
+	if len(columns) == 0 {
+		// JSON objects without projection, every key found in the page
+		keys := make(map[string]bool)
+		for _, record := range records {
+			for key := range record {
+				if !keys[key] {
+					keys[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+		sort.Strings(columns)
+	}
+	result.Columns = columns
+	if result.Columns == nil {
+		result.Columns = make([]string, 0)
+	}
+	for _, record := range records {
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			row[i] = recordValue(record, column)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, nil
+}
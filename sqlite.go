@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type SqliteColumn struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	NotNull    bool   `json:"notNull"`
+	PrimaryKey bool   `json:"primaryKey"`
+	Default    string `json:"default,omitempty"`
+}
+
+type SqliteTable struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"` // table / view
+	Schema  string         `json:"schema"`
+	Columns []SqliteColumn `json:"columns"`
+	Rows    int64          `json:"rows"`
+}
+
+type SqliteRows struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	Offset  int             `json:"offset"`
+	Total   int64           `json:"total"`
+}
+
+// copies of databases are kept for a while, so that browsing pages of rows does not copy them again
+const sqliteCacheTTL = 5 * time.Minute
+const sqliteCacheSize = 4
+
+type stagedDatabase struct {
+	dir     string
+	db      *sql.DB
+	size    int64 // bytes of the copy
+	usedAt  time.Time
+	users   int  // requests querying the database
+	evicted bool // no longer in the cache, closed once not used
+}
+
+// copies of databases on local disk. The total size includes copies in progress and evicted ones still in use,
+// and is kept within `sqliteCacheSize` of the config
+var sqliteCache = struct {
+	sync.Mutex
+	databases map[string]*stagedDatabase
+	size      int64
+}{databases: make(map[string]*stagedDatabase)}
+
+// close removes the copy, the cache must be locked
+func (self *stagedDatabase) close() {
+	self.db.Close()
+	os.RemoveAll(self.dir)
+	sqliteCache.size -= self.size
+}
+
+// evictSqlite removes the database from the cache, and closes it unless it is in use. The cache must be locked
+func evictSqlite(key string, staged *stagedDatabase) {
+	delete(sqliteCache.databases, key)
+	staged.evicted = true
+	if staged.users == 0 {
+		staged.close()
+	}
+}
+
+// sweepSqliteCache evicts databases not used within the TTL, then the least recently used ones beyond the number
+// of databases, or until `needed` more bytes fit in the budget. The cache must be locked
+func sweepSqliteCache(needed int64) {
+	for key, staged := range sqliteCache.databases {
+		if time.Since(staged.usedAt) >= sqliteCacheTTL {
+			evictSqlite(key, staged)
+		}
+	}
+	for len(sqliteCache.databases) > 0 &&
+		(len(sqliteCache.databases) > sqliteCacheSize || sqliteCache.size+needed > serverConfig.SqliteCacheSize) {
+		var oldestKey string
+		var oldest *stagedDatabase
+		for key, staged := range sqliteCache.databases {
+			if oldest == nil || staged.usedAt.Before(oldest.usedAt) {
+				oldestKey, oldest = key, staged
+			}
+		}
+		evictSqlite(oldestKey, oldest)
+	}
+}
+
+// releaseSqlite is called once the request is done with the database returned by openSqlite
+func releaseSqlite(staged *stagedDatabase) {
+	sqliteCache.Lock()
+	defer sqliteCache.Unlock()
+	staged.users--
+	staged.usedAt = time.Now()
+	if staged.evicted && staged.users == 0 {
+		staged.close()
+	}
+}
+
+// pullFile copies the first `size` bytes of a file of the container to the local path
+func pullFile(podName string, containerName string, path string, namespace string, token string, size int64, localPath string) error {
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	cmd := []string{"head", "-c", strconv.FormatInt(size, 10), "--", path}
+	return execCmdToWriter(context.Background(), podName, containerName, namespace, token, cmd, file)
+}
+
+// openSqlite copies the database (and its write-ahead log if any) from the container, and opens it read-only.
+// The file is checked with the caller's token on every call, the copy is reused while its mtime and size are unchanged.
+// The database is in use until releaseSqlite is called
+func openSqlite(podName string, containerName string, path string, namespace string, token string) (*stagedDatabase, error) {
+	stat, err := GetFileStat(podName, containerName, path, namespace, token, "")
+	if err != nil {
+		return nil, err
+	}
+	if stat.Type != "regular file" || stat.Size == nil {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+	// committed transactions may still be in the write-ahead log. Files are copied up to the size found here,
+	// frames appended to the log meanwhile are incomplete and ignored by SQLite
+	var walSize int64
+	if walStat, err := GetFileStat(podName, containerName, path+"-wal", namespace, token, ""); err == nil && walStat.Size != nil {
+		walSize = *walStat.Size
+	}
+	needed := *stat.Size + walSize
+	if needed > serverConfig.SqliteCacheSize {
+		return nil, fmt.Errorf("Database is larger than %d bytes allowed by sqliteCacheSize", serverConfig.SqliteCacheSize)
+	}
+
+	key := fmt.Sprintf("%s/%s/%s:%s@%d/%d", namespace, podName, containerName, path, stat.ModifyTime, *stat.Size)
+	sqliteCache.Lock()
+	if staged, ok := sqliteCache.databases[key]; ok {
+		staged.users++
+		staged.usedAt = time.Now()
+		sqliteCache.Unlock()
+		return staged, nil
+	}
+	sweepSqliteCache(needed)
+	if sqliteCache.size+needed > serverConfig.SqliteCacheSize {
+		sqliteCache.Unlock()
+		return nil, fmt.Errorf("Other databases being browsed use the %d bytes allowed by sqliteCacheSize, try again later", serverConfig.SqliteCacheSize)
+	}
+	// reserved until the copy is closed
+	sqliteCache.size += needed
+	sqliteCache.Unlock()
+
+	staged := &stagedDatabase{size: needed, users: 1, usedAt: time.Now()}
+	staged.dir, err = ioutil.TempDir("", "pod-inspector-sqlite")
+	if err == nil {
+		localPath := filepath.Join(staged.dir, "database")
+		err = pullFile(podName, containerName, path, namespace, token, *stat.Size, localPath)
+		if err == nil && walSize > 0 {
+			err = pullFile(podName, containerName, path+"-wal", namespace, token, walSize, localPath+"-wal")
+		}
+		if err == nil {
+			staged.db, err = sql.Open("sqlite", "file:"+localPath+"?mode=ro&_pragma=query_only(1)")
+		}
+		if err == nil {
+			err = staged.db.Ping()
+		}
+	}
+	if err != nil {
+		sqliteCache.Lock()
+		if staged.db != nil {
+			staged.close()
+		} else {
+			os.RemoveAll(staged.dir)
+			sqliteCache.size -= needed
+		}
+		sqliteCache.Unlock()
+		return nil, err
+	}
+
+	sqliteCache.Lock()
+	if existing, ok := sqliteCache.databases[key]; ok {
+		// copied concurrently by another request
+		existing.users++
+		existing.usedAt = time.Now()
+		staged.close()
+		sqliteCache.Unlock()
+		return existing, nil
+	}
+	sqliteCache.databases[key] = staged
+	sqliteCache.Unlock()
+	time.AfterFunc(sqliteCacheTTL, func() {
+		sqliteCache.Lock()
+		defer sqliteCache.Unlock()
+		sweepSqliteCache(0)
+	})
+	return staged, nil
+}
+
+// quoteIdentifier quotes a table or column name for SQL
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// GetSqliteTables lists tables and views of a SQLite database in the container, with their columns and row count
+func GetSqliteTables(podName string, containerName string, path string, namespace string, token string) ([]SqliteTable, error) {
+	staged, err := openSqlite(podName, containerName, path, namespace, token)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseSqlite(staged)
+	db := staged.db
+
+	rows, err := db.Query("SELECT name, type, COALESCE(sql, '') FROM sqlite_master WHERE type IN ('table', 'view') ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]SqliteTable, 0)
+	for rows.Next() {
+		var table SqliteTable
+		if err := rows.Scan(&table.Name, &table.Type, &table.Schema); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+
+	for i := range tables {
+		table := &tables[i]
+		table.Columns = make([]SqliteColumn, 0)
+		columns, err := db.Query("SELECT name, type, \"notnull\", pk, COALESCE(dflt_value, '') FROM pragma_table_info(?)", table.Name)
+		if err != nil {
+			return nil, err
+		}
+		for columns.Next() {
+			var column SqliteColumn
+			var primaryKey int
+			if err := columns.Scan(&column.Name, &column.Type, &column.NotNull, &primaryKey, &column.Default); err != nil {
+				columns.Close()
+				return nil, err
+			}
+			column.PrimaryKey = primaryKey > 0
+			table.Columns = append(table.Columns, column)
+		}
+		columns.Close()
+
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + quoteIdentifier(table.Name)).Scan(&table.Rows); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// GetSqliteRows returns a page of rows of the table or view, in the order SQLite stores them
+func GetSqliteRows(podName string, containerName string, path string, namespace string, token string, table string, offset int, limit int) (*SqliteRows, error) {
+	staged, err := openSqlite(podName, containerName, path, namespace, token)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseSqlite(staged)
+	db := staged.db
+
+	// the name is checked against the schema, so that only existing tables are queried
+	var tableType string
+	err = db.QueryRow("SELECT type FROM sqlite_master WHERE type IN ('table', 'view') AND name = ?", table).Scan(&tableType)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("No table %s", table)
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := &SqliteRows{Offset: offset, Rows: make([][]interface{}, 0)}
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + quoteIdentifier(table)).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT * FROM "+quoteIdentifier(table)+" LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if result.Columns, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(result.Columns))
+		pointers := make([]interface{}, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, rows.Err()
+}
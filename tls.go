@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// interval to check whether certificate files have been changed on disk
+const tlsReloadInterval = 10 * time.Second
+
+// protocols negotiated by ALPN, the config returned for each client replaces the one http.Server adds h2 to
+var tlsNextProtos = []string{"h2", "http/1.1"}
+
+// certReloader serves the latest certificate and client CA bundle,
+// files are reloaded when their modification time changes, e.g. when cert-manager renews a secret
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mutex     sync.Mutex
+	checkedAt time.Time
+	modTime   time.Time
+	cert      *tls.Certificate
+	caPool    *x509.CertPool
+}
+
+func newCertReloader(certFile string, keyFile string, caFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// latest modification time among the files
+func (self *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{self.certFile, self.keyFile, self.caFile} {
+		if len(name) == 0 {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (self *certReloader) load() error {
+	modTime, err := self.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(self.certFile, self.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var caPool *x509.CertPool
+	if len(self.caFile) > 0 {
+		pem, err := ioutil.ReadFile(self.caFile)
+		if err != nil {
+			return err
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificate found in CA bundle %s", self.caFile)
+		}
+	}
+
+	self.cert = &cert
+	self.caPool = caPool
+	self.modTime = modTime
+	self.checkedAt = time.Now()
+	return nil
+}
+
+// reload files if they were changed, the previous ones are kept in use if they cannot be loaded
+func (self *certReloader) refresh() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if time.Since(self.checkedAt) < tlsReloadInterval {
+		return
+	}
+	self.checkedAt = time.Now()
+
+	modTime, err := self.lastModified()
+	if err != nil || !modTime.After(self.modTime) {
+		return
+	}
+	if err = self.load(); err != nil {
+		fmt.Println("Unable to reload TLS certificate :", err)
+	} else {
+		fmt.Println("TLS certificate reloaded")
+	}
+}
+
+func (self *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	self.refresh()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.cert, nil
+}
+
+func (self *certReloader) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	self.refresh()
+
+	self.mutex.Lock()
+	caPool := self.caPool
+	self.mutex.Unlock()
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: self.GetCertificate,
+		NextProtos:     tlsNextProtos,
+	}
+	if caPool != nil {
+		// kubelet probes have no client certificate, it is required by requireClientCert on other routes
+		config.ClientCAs = caPool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// requireClientCert rejects requests without a client certificate verified against the CA bundle
+func requireClientCert(c *gin.Context) {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]string{"error": "Client certificate required"})
+		return
+	}
+	c.Next()
+}
+
+// NewServerTLSConfig creates TLS settings of the HTTP server,
+// client certificates are verified when given if a CA bundle is configured
+func NewServerTLSConfig(cfg *Config) (*tls.Config, error) {
+	if len(cfg.TLSCert) == 0 || len(cfg.TLSKey) == 0 {
+		return nil, errors.New("Both TLS certificate and key must be specified")
+	}
+
+	reloader, err := newCertReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetCertificate:     reloader.GetCertificate,
+		GetConfigForClient: reloader.GetConfigForClient,
+		NextProtos:         tlsNextProtos,
+	}, nil
+}
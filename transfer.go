@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type TransferResult struct {
+	Source   FileTarget `json:"source"`
+	Target   FileTarget `json:"target"`   // path of the copy, within the target directory
+	Bytes    int64      `json:"bytes"`    // size of the tar stream
+	Duration float64    `json:"duration"` // in seconds
+}
+
+// countingWriter counts bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (self *countingWriter) Write(p []byte) (int, error) {
+	n, err := self.writer.Write(p)
+	atomic.AddInt64(&self.count, int64(n))
+	return n, err
+}
+
+// splitPath returns the parent directory and the name of an absolute path
+func splitPath(path string) (string, string) {
+	path = strings.TrimRight(path, "/")
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/", path[1:]
+	}
+	return path[:i], path[i+1:]
+}
+
+// TransferFiles copies the file or directory at source into the directory targetDir of another pod or container.
+// `tar` output of the source exec is piped into `tar` reading stdin in the target exec, nothing is staged locally.
+// Cancelling the context, or either side failing, ends both execs
+func TransferFiles(ctx context.Context, source FileTarget, targetDir FileTarget, namespace string, token string) (*TransferResult, error) {
+	if err := checkPath("transfer", source.Path); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(targetDir.Path, "/") {
+		return nil, invalidFileOperation("transfer", targetDir.Path, "Path must be absolute")
+	}
+	// `tar` would overwrite the files while reading them, or copy a directory into itself
+	sourcePath := path.Clean(source.Path)
+	copyPath := path.Join(targetDir.Path, path.Base(sourcePath))
+	if source.Pod == targetDir.Pod && source.Container == targetDir.Container &&
+		(copyPath == sourcePath || strings.HasPrefix(copyPath, sourcePath+"/")) {
+		return nil, invalidFileOperation("transfer", targetDir.Path, "Unable to transfer %s onto itself", source.Path)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := []string{"mkdir", "-p", "--", targetDir.Path}
+	if err := runFileCommand(targetDir.Pod, targetDir.Container, namespace, token, "transfer", targetDir.Path, cmd); err != nil {
+		return nil, err
+	}
+
+	dir, name := splitPath(source.Path)
+	reader, writer := io.Pipe()
+	stdout := &countingWriter{writer: writer}
+
+	// closing the pipe fails both sides, the source on writing and the target on reading stdin
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			reader.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	start := time.Now()
+	var sourceErr, targetErr error
+	parallel(func() error {
+		_, err := execCmdWithStdin(ctx, targetDir.Pod, targetDir.Container, namespace, token, []string{"tar", "-xf", "-", "-C", targetDir.Path}, reader)
+		if err != nil {
+			// end the source, which has nobody reading its output anymore
+			cancel()
+			reader.CloseWithError(io.ErrClosedPipe)
+			targetErr = newFileOperationError("transfer", targetDir.Path, err)
+			return nil
+		}
+		// `tar` may exit at the end marker of the archive, while the source still writes the padding after it
+		io.Copy(ioutil.Discard, reader)
+		return nil
+	}, func() error {
+		err := execCmdToWriter(ctx, source.Pod, source.Container, namespace, token, []string{"tar", "-cf", "-", "-C", dir, "--", name}, stdout)
+		// end of the archive, or the error for the target to fail with
+		writer.CloseWithError(err)
+		if err != nil {
+			cancel()
+			sourceErr = newFileOperationError("transfer", source.Path, err)
+		}
+		return nil
+	})
+
+	// when one side fails, the other fails too. The source is the cause if its `tar` exited by itself,
+	// otherwise it failed writing to the target
+	if opErr, ok := sourceErr.(*FileOperationError); ok && opErr.ExitCode >= 0 {
+		return nil, sourceErr
+	}
+	if targetErr != nil {
+		return nil, targetErr
+	}
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+
+	target := targetDir
+	target.Path = strings.TrimRight(targetDir.Path, "/") + "/" + name
+	return &TransferResult{
+		Source:   source,
+		Target:   target,
+		Bytes:    atomic.LoadInt64(&stdout.count),
+		Duration: time.Since(start).Seconds(),
+	}, nil
+}
@@ -6,6 +6,45 @@ export interface IContainer {
   cpuLimit: number;
   ramUsage: number;
   ramLimit: number;
+  cpuRequest: number;
+  cpuPercentage: number;
+  cpuRequestPercentage: number;
+  ramRequest: number;
+  ramPercentage: number;
+  ramRequestPercentage: number;
+  storageRequest: number;
+  storageLimit: number;
+  missingResources?: string[]; // e.g. requests.cpu, limits.memory
+  image: string;
+  state: string; // running / waiting / terminated
+  reason?: string;
+  message?: string;
+  exitCode?: number;
+  startedAt?: number;
+  lastState?: IContainerTermination;
+}
+
+export interface IContainerTermination {
+  reason: string;
+  message?: string;
+  exitCode: number;
+  signal?: number;
+  startedAt: number;
+  finishedAt: number;
+}
+
+export interface IOwner {
+  kind: string;
+  name: string;
+  controller: boolean;
+}
+
+export interface IPodCondition {
+  type: string;
+  status: string;
+  reason?: string;
+  message?: string;
+  lastTransitionTime: number;
 }
 
 export interface IPodFile {
@@ -19,18 +58,34 @@ export interface IPodFile {
 
 export default interface IPod {
   name: string;
+  namespace: string;
   status: string;
+  reason?: string;
   age: number;
   ready: number;
   hostIp: string;
   podIp: string;
+  nodeName: string;
+  qosClass: string;
+  labels: { [key: string]: string } | null;
+  annotations: { [key: string]: string } | null;
+  owners: IOwner[];
+  conditions: IPodCondition[];
   cpuUsage: number;
   cpuLimit: number;
   cpuPercentage: number;
   ramUsage: number;
   ramLimit: number;
   ramPercentage: number;
+  cpuRequest: number;
+  cpuRequestPercentage: number;
+  ramRequest: number;
+  ramRequestPercentage: number;
+  storageRequest: number;
+  storageLimit: number;
   containers : Map<string, IContainer>;
+  initContainers : Map<string, IContainer>;
+  ephemeralContainers : Map<string, IContainer>;
 }
 
 
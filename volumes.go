@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type K8sVolumeMount struct {
+	Container string `json:"container"`
+	MountPath string `json:"mountPath"` // starting path to browse files of the volume in the container
+	SubPath   string `json:"subPath,omitempty"`
+	ReadOnly  bool   `json:"readOnly"`
+	// from `df` in the container, nil if unavailable
+	Capacity        *int64  `json:"capacity,omitempty"`  // KB
+	Used            *int64  `json:"used,omitempty"`      // KB
+	Available       *int64  `json:"available,omitempty"` // KB
+	UsagePercentage float32 `json:"usagePercentage"`
+}
+
+type K8sPersistentVolume struct {
+	Name          string                               `json:"name"`
+	Capacity      int64                                `json:"capacity"` // KB
+	Phase         corev1.PersistentVolumePhase         `json:"phase"`
+	ReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy"`
+	Driver        string                               `json:"driver,omitempty"` // CSI driver
+	Handle        string                               `json:"handle,omitempty"` // CSI volume handle
+}
+
+type K8sVolumeClaim struct {
+	Name         string                              `json:"name"`
+	Phase        corev1.PersistentVolumeClaimPhase   `json:"phase"`
+	StorageClass string                              `json:"storageClass"`
+	Request      int64                               `json:"request"`  // KB
+	Capacity     int64                               `json:"capacity"` // KB
+	AccessModes  []corev1.PersistentVolumeAccessMode `json:"accessModes"`
+	Volume       *K8sPersistentVolume                `json:"volume,omitempty"` // nil if not bound or not accessible
+}
+
+type K8sVolume struct {
+	Name      string           `json:"name"`
+	Type      string           `json:"type"`   // persistentVolumeClaim, configMap, secret, emptyDir, hostPath, projected...
+	Source    string           `json:"source"` // claim / config map / secret name, host path, or sources of projected volume
+	ReadOnly  bool             `json:"readOnly"`
+	Medium    string           `json:"medium,omitempty"`    // emptyDir medium, e.g. Memory
+	SizeLimit int64            `json:"sizeLimit,omitempty"` // emptyDir size limit in KB
+	Claim     *K8sVolumeClaim  `json:"claim,omitempty"`
+	Mounts    []K8sVolumeMount `json:"mounts"`
+}
+
+type diskUsage struct {
+	capacity  int64
+	used      int64
+	available int64
+}
+
+// getMountUsage runs `df` in the container and returns usage by mount point, in KB
+func getMountUsage(podName string, containerName string, namespace string, token string) (map[string]diskUsage, error) {
+	// -P for POSIX output which is the same in BusyBox and coreutils, one line per file system
+	cmd := []string{"df", "-kP"}
+	buffer, err := execCmd(podName, containerName, namespace, token, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filesystem           1024-blocks    Used Available Capacity Mounted on
+	// /dev/sda1               98831908 5271280  93544244       6% /data
+	result := make(map[string]diskUsage)
+	scanner := bufio.NewScanner(bytes.NewReader(buffer))
+	for scanner.Scan() {
+		columns := spaceRegex.Split(strings.TrimSpace(scanner.Text()), 6)
+		if len(columns) < 6 {
+			continue
+		}
+		capacity, err1 := strconv.ParseInt(columns[1], 10, 64)
+		used, err2 := strconv.ParseInt(columns[2], 10, 64)
+		available, err3 := strconv.ParseInt(columns[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue // header
+		}
+		result[columns[5]] = diskUsage{capacity, used, available}
+	}
+	return result, nil
+}
+
+func newK8sVolume(volume *corev1.Volume) K8sVolume {
+	result := K8sVolume{
+		Name:   volume.Name,
+		Type:   "other",
+		Mounts: make([]K8sVolumeMount, 0),
+	}
+
+	switch {
+	case volume.PersistentVolumeClaim != nil:
+		result.Type = "persistentVolumeClaim"
+		result.Source = volume.PersistentVolumeClaim.ClaimName
+		result.ReadOnly = volume.PersistentVolumeClaim.ReadOnly
+	case volume.ConfigMap != nil:
+		result.Type = "configMap"
+		result.Source = volume.ConfigMap.Name
+		result.ReadOnly = true
+	case volume.Secret != nil:
+		result.Type = "secret"
+		result.Source = volume.Secret.SecretName
+		result.ReadOnly = true
+	case volume.EmptyDir != nil:
+		result.Type = "emptyDir"
+		result.Medium = string(volume.EmptyDir.Medium)
+		if volume.EmptyDir.SizeLimit != nil {
+			result.SizeLimit = volume.EmptyDir.SizeLimit.ScaledValue(resource.Kilo)
+		}
+	case volume.HostPath != nil:
+		result.Type = "hostPath"
+		result.Source = volume.HostPath.Path
+	case volume.Projected != nil:
+		result.Type = "projected"
+		result.ReadOnly = true
+		sources := make([]string, 0, len(volume.Projected.Sources))
+		for _, source := range volume.Projected.Sources {
+			switch {
+			case source.ConfigMap != nil:
+				sources = append(sources, "configMap/"+source.ConfigMap.Name)
+			case source.Secret != nil:
+				sources = append(sources, "secret/"+source.Secret.Name)
+			case source.ServiceAccountToken != nil:
+				sources = append(sources, "serviceAccountToken")
+			case source.DownwardAPI != nil:
+				sources = append(sources, "downwardAPI")
+			}
+		}
+		result.Source = strings.Join(sources, ",")
+	case volume.DownwardAPI != nil:
+		result.Type = "downwardAPI"
+		result.ReadOnly = true
+	case volume.CSI != nil:
+		result.Type = "csi"
+		result.Source = volume.CSI.Driver
+	case volume.NFS != nil:
+		result.Type = "nfs"
+		result.Source = volume.NFS.Server + ":" + volume.NFS.Path
+		result.ReadOnly = volume.NFS.ReadOnly
+	case volume.Ephemeral != nil:
+		result.Type = "ephemeral"
+	}
+	return result
+}
+
+func getVolumeClaim(ctx context.Context, client *k8sClient, namespace string, claimName string) (*K8sVolumeClaim, error) {
+	pvc, err := client.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, claimName, metav1.GetOptions{})
+	if err != nil {
+		return nil, countApiError("persistentvolumeclaims.get", err)
+	}
+
+	claim := &K8sVolumeClaim{
+		Name:        pvc.Name,
+		Phase:       pvc.Status.Phase,
+		Request:     pvc.Spec.Resources.Requests.Storage().ScaledValue(resource.Kilo),
+		Capacity:    pvc.Status.Capacity.Storage().ScaledValue(resource.Kilo),
+		AccessModes: pvc.Status.AccessModes,
+	}
+	if pvc.Spec.StorageClassName != nil {
+		claim.StorageClass = *pvc.Spec.StorageClassName
+	}
+
+	// persistent volumes are cluster-scoped, which may not be granted
+	if len(pvc.Spec.VolumeName) > 0 {
+		pv, err := client.clientset.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		if err == nil {
+			claim.Volume = &K8sPersistentVolume{
+				Name:          pv.Name,
+				Capacity:      pv.Spec.Capacity.Storage().ScaledValue(resource.Kilo),
+				Phase:         pv.Status.Phase,
+				ReclaimPolicy: pv.Spec.PersistentVolumeReclaimPolicy,
+			}
+			if pv.Spec.CSI != nil {
+				claim.Volume.Driver = pv.Spec.CSI.Driver
+				claim.Volume.Handle = pv.Spec.CSI.VolumeHandle
+			}
+		}
+	}
+	return claim, nil
+}
+
+// GetVolumes maps volumes of the pod to their mounts in each container, with disk usage of running containers
+func GetVolumes(podName string, namespace string, token string) ([]K8sVolume, error) {
+	ctx := context.TODO()
+	client, err := getClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := client.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, countApiError("pods.get", err)
+	}
+
+	volumes := make([]K8sVolume, 0, len(pod.Spec.Volumes))
+	volumeIndex := make(map[string]int)
+	for i := range pod.Spec.Volumes {
+		volume := newK8sVolume(&pod.Spec.Volumes[i])
+		if volume.Type == "persistentVolumeClaim" {
+			if claim, err := getVolumeClaim(ctx, client, namespace, volume.Source); err == nil {
+				volume.Claim = claim
+			}
+		}
+		volumeIndex[volume.Name] = len(volumes)
+		volumes = append(volumes, volume)
+	}
+
+	running := make(map[string]bool)
+	for _, cs := range pod.Status.ContainerStatuses {
+		running[cs.Name] = cs.State.Running != nil
+	}
+
+	for _, container := range pod.Spec.Containers {
+		var usages map[string]diskUsage
+		if running[container.Name] {
+			usages, _ = getMountUsage(podName, container.Name, namespace, token)
+		}
+
+		for _, vm := range container.VolumeMounts {
+			i, ok := volumeIndex[vm.Name]
+			if !ok {
+				continue
+			}
+			mount := K8sVolumeMount{
+				Container: container.Name,
+				MountPath: vm.MountPath,
+				SubPath:   vm.SubPath,
+				ReadOnly:  vm.ReadOnly || volumes[i].ReadOnly,
+			}
+			if usage, ok := usages[strings.TrimRight(vm.MountPath, "/")]; ok {
+				mount.Capacity = &usage.capacity
+				mount.Used = &usage.used
+				mount.Available = &usage.available
+				mount.UsagePercentage = percentage(usage.used, usage.capacity)
+			}
+			volumes[i].Mounts = append(volumes[i].Mounts, mount)
+		}
+	}
+
+	sort.SliceStable(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}